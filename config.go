@@ -1,37 +1,48 @@
 package cfg
 
 import (
-	"bufio"
 	"fmt"
 	"io"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // Config implements access to configuration values.
+// A Config is safe for concurrent use by multiple goroutines.
 type Config struct {
-	raw             []string
-	comments        []string
-	values          map[string]string
-	keyValuePattern *regexp.Regexp
+	mu         sync.RWMutex
+	raw        []string
+	values     map[string]string
+	strict     bool
+	duplicates DuplicatePolicy
+	envPrefix  string
+	journal    bool            // Record changes, only set for a ConfigFile
+	changes    []func(*Config) // Changes not yet persisted, see record
+	sources    []string        // File of each raw entry, nil if all are from one file
+
+	layerPrefixes []string // Environment prefixes of the layers of a Stack
 }
 
 // NewConfig creates a new empty configuration.
-func NewConfig() *Config {
-	return &Config{
-		raw:             make([]string, 0),
-		comments:        make([]string, 0),
-		values:          make(map[string]string),
-		keyValuePattern: regexp.MustCompile(`\s*(\S+)\s*=\s*(\S+)\s*`),
+func NewConfig(options ...Option) *Config {
+	c := &Config{
+		raw:    make([]string, 0),
+		values: make(map[string]string),
+	}
+	for _, option := range options {
+		option(c)
 	}
+
+	return c
 }
 
 // NewConfigFromReader creates a new empty config and populates it
 // with data parsed from the reader.
 // If a error occurs when parsing the input an error is returned.
-func NewConfigFromReader(r io.Reader) (*Config, error) {
-	c := NewConfig()
+// In strict mode, see Strict, malformed lines are reported as a *ParseError.
+func NewConfigFromReader(r io.Reader, options ...Option) (*Config, error) {
+	c := NewConfig(options...)
 
 	err := c.parse(r)
 	if err != nil {
@@ -41,86 +52,248 @@ func NewConfigFromReader(r io.Reader) (*Config, error) {
 	return c, nil
 }
 
-// GetString returns the value for key as a string with new lines unescaped.
-// If the key is not found an error is returned.
+// GetString returns the value for key as a string.
+// Surrounding double quotes are removed and escape sequences are replaced by
+// the characters they represent.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
 func (c *Config) GetString(key string) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	val, err := c.get(key)
 	if err != nil {
 		return "", err
 	}
 
-	uq := strings.Replace(val, "\\n", "\n", -1)
-	return uq, nil
+	return decodeValue(val), nil
+}
+
+// GetAll returns all values defined for key as strings, in the order they
+// are defined. See GetString.
+// A key has more than one value if it is defined more than once, see
+// DuplicatePolicy.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+func (c *Config) GetAll(key string) ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, ok := c.lookupEnv(key); ok {
+		val, err := c.get(key)
+		if err != nil {
+			return nil, err
+		}
+		return []string{decodeValue(val)}, nil
+	}
+	indexes := c.indexes(key)
+	if len(indexes) == 0 {
+		return nil, keyNotFound(key)
+	}
+
+	values := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		_, val, _ := splitKeyValue(c.raw[i])
+		val, err := c.expand(key, val, nil)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, decodeValue(val))
+	}
+
+	return values, nil
 }
 
 // GetInt returns the value for key as an int in decimal base.
-// If the key is not found an error is returned.
-// If the value can not be represented as an integer an error is returned.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If the value can not be represented as an integer a *ValueError is returned.
 func (c *Config) GetInt(key string) (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	val, err := c.get(key)
 	if err != nil {
 		return 0, err
 	}
-	i, err := strconv.ParseInt(val, 10, 64)
+	i, err := strconv.ParseInt(decodeValue(val), 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("Invalid integer (%s)", err)
+		return 0, &ValueError{Key: key, Value: val, Type: "integer", Err: err}
 	}
 
 	return int(i), nil
 }
 
 // GetFloat returns the value for key as a float64.
-// If the key is not found an error is returned.
-// If the value can not be represented as a float an error is returned.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If the value can not be represented as a float a *ValueError is returned.
 func (c *Config) GetFloat(key string) (float64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	val, err := c.get(key)
 	if err != nil {
 		return 0, err
 	}
-	f, err := strconv.ParseFloat(val, 64)
+	f, err := strconv.ParseFloat(decodeValue(val), 64)
 	if err != nil {
-		return 0, fmt.Errorf("Invalid float (%s)", err)
+		return 0, &ValueError{Key: key, Value: val, Type: "float", Err: err}
 	}
 
 	return f, nil
 }
 
 // GetBool returns the value for key as a bool.
-// If the key is not found an error is returned.
-// If the value can not be represented as a boolean an error is returned.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If the value can not be represented as a boolean a *ValueError is returned.
 func (c *Config) GetBool(key string) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	val, err := c.get(key)
 	if err != nil {
 		return false, err
 	}
-	b, err := strconv.ParseBool(val)
+	b, err := strconv.ParseBool(decodeValue(val))
 	if err != nil {
-		return false, fmt.Errorf("Invalid boolean (%s)", err)
+		return false, &ValueError{Key: key, Value: val, Type: "boolean", Err: err}
 	}
 
 	return b, nil
 }
 
-// Comments returns all parsed comments in the config as a list of strings.
-// Comments can not be modified programatically, but can be read.
+// Comments returns all comments in the config as a list of strings.
 // The comments are in the order they are defined in the source config.
 func (c *Config) Comments() []string {
-	// Should be a copy of the data as the user could modify the data,
-	// but the comments are not modifiable so even if the user changes
-	// the data it will not affect the configuration.
-	return c.comments
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	comments := make([]string, 0)
+	for _, line := range c.raw {
+		if isComment(line) {
+			comments = append(comments, commentText(line))
+		}
+	}
+
+	return comments
+}
+
+// KeyComment returns the comment attached to key.
+// The comment attached to a key is the block of comment lines directly above
+// it, without any empty lines in between. Multiple comment lines are joined
+// with new lines.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+func (c *Config) KeyComment(key string) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	i := c.index(key)
+	if i == -1 {
+		return "", keyNotFound(key)
+	}
+
+	start := c.commentStart(i)
+	lines := make([]string, 0, i-start)
+	for _, line := range c.raw[start:i] {
+		lines = append(lines, commentText(line))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// SetKeyComment replaces the comment attached to key.
+// Each line in comment is written as a comment line directly above the key
+// with the same indentation as the key. An empty comment removes the comment.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+func (c *Config) SetKeyComment(key, comment string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setKeyComment(key, comment)
+}
+
+// SetWithComment creates or updates a string value attached to key and
+// replaces the comment attached to it.
+// See SetString and SetKeyComment.
+func (c *Config) SetWithComment(key, value, comment string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, encodeValue(value))
+	// The key is always defined after set, so no error can occur
+	_ = c.setKeyComment(key, comment)
+}
+
+// setKeyComment is the internal version of SetKeyComment.
+func (c *Config) setKeyComment(key, comment string) error {
+	i := c.index(key)
+	if i == -1 {
+		return keyNotFound(key)
+	}
+	c.record(func(c *Config) {
+		c.setKeyComment(key, comment)
+	})
+
+	start := c.commentStart(i)
+	indent := indentation(c.raw[i])
+	lines := make([]string, 0)
+	if comment != "" {
+		for _, line := range strings.Split(comment, "\n") {
+			lines = append(lines, strings.TrimRight(indent+"# "+line, " "))
+		}
+	}
+
+	source := c.sourceAt(i)
+	c.removeRaw(start, i-start)
+	c.insertRaw(start, source, lines...)
+
+	return nil
 }
 
 // SetString creates or updates a value attached to key.
-// Any new lines in the value are escaped.
+// Line breaks, tabs, backslashes, other control characters and bytes that are
+// not valid UTF-8 in the value are escaped. The value is quoted if it is
+// empty, starts or ends with whitespace or starts with a double quote, so that
+// it is read back exactly.
+// If the key already has a heredoc value, see SetMultilineString, and the new
+// value contains line breaks the heredoc form is kept.
 func (c *Config) SetString(key, value string) {
-	qval := strings.Replace(value, "\n", "\\n", -1)
-	c.set(key, qval)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if i := c.index(key); i != -1 && isHeredoc(c.raw[i]) {
+		if text, ok := encodeHeredoc(value); ok {
+			c.set(key, text)
+			return
+		}
+	}
+	c.set(key, encodeValue(value))
+}
+
+// SetMultilineString creates or updates a value attached to key.
+// A value with line breaks is written over multiple lines as a heredoc, eg.
+//
+//	key = <<EOF
+//	first line
+//	second line
+//	EOF
+//
+// Other values, and values that can not be written as a heredoc, are written
+// as with SetString.
+func (c *Config) SetMultilineString(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if text, ok := encodeHeredoc(value); ok {
+		c.set(key, text)
+		return
+	}
+	c.set(key, encodeValue(value))
 }
 
 // SetInt creates or updates a value attached to key.
 // All integer values are formated in decimal base.
 func (c *Config) SetInt(key string, value int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	qval := strconv.FormatInt(int64(value), 10)
 	c.set(key, qval)
 }
@@ -128,6 +301,9 @@ func (c *Config) SetInt(key string, value int) {
 // SetFloat creates or updates a value attached to key.
 // The float value is formated without exponents eg. 3.14 not 3.14E+00.
 func (c *Config) SetFloat(key string, value float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	qval := strconv.FormatFloat(value, 'f', -1, 64)
 	c.set(key, qval)
 }
@@ -135,81 +311,627 @@ func (c *Config) SetFloat(key string, value float64) {
 // SetBool creates or updates a value attached to key.
 // The bool value is formated as "true" or "false".
 func (c *Config) SetBool(key string, value bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	qval := strconv.FormatBool(value)
 	c.set(key, qval)
 }
 
 // Unset deletes a value from the config.
+// All definitions of a duplicated key are deleted.
 // Any comments defined in the source are preserved.
 func (c *Config) Unset(key string) {
-	for i, line := range c.raw {
-		matches := c.keyValuePattern.FindStringSubmatch(line)
-		if len(matches) == 3 {
-			if matches[1] == key {
-				c.raw = append(c.raw[:i], c.raw[i+1:]...)
-				delete(c.values, key)
-				return
-			}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.unset(key)
+}
+
+// Snapshot returns a copy of the config that is not affected by later changes
+// to c, and changes to the copy do not affect c.
+func (c *Config) Snapshot() *Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s := &Config{}
+	s.copyFrom(c)
+
+	return s
+}
+
+// unset is the internal version of Unset.
+func (c *Config) unset(key string) {
+	c.record(func(c *Config) {
+		c.unset(key)
+	})
+	indexes := c.indexes(key)
+	for j := len(indexes) - 1; j >= 0; j-- {
+		c.removeRaw(indexes[j], 1)
+	}
+	delete(c.values, key)
+}
+
+// Duplicates returns the line numbers of all definitions of each key that is
+// defined more than once. Keys that are only defined once are not included.
+func (c *Config) Duplicates() map[string][]int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	lines := make(map[string][]int)
+	c.scan(func(i int, section string) bool {
+		if k, _, ok := splitKeyValue(c.raw[i]); ok {
+			key := qualify(section, k)
+			lines[key] = append(lines[key], c.lineNumber(i))
+		}
+		return true
+	})
+
+	duplicates := make(map[string][]int)
+	for key, l := range lines {
+		if len(l) > 1 {
+			duplicates[key] = l
 		}
 	}
+
+	return duplicates
+}
+
+// Sections returns the names of all sections in the order they are defined.
+// Keys in a section are accessed with the name of the section and the key
+// joined by a dot, eg. "db.host" for the key host in the section db.
+func (c *Config) Sections() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.sections()
 }
 
 // String returns a string representation of the config.
 // All comments and values are present.
 // Whitespaces are preserved as they were in the source that were parsed if any.
 func (c *Config) String() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	return strings.Join(c.raw, "\n")
 }
 
 // get is the internal getter that only operates on strings.
-// Returns errors if the key is undefined.
+// Returns the raw value with all references replaced.
+// Returns an error wrapping ErrKeyNotFound if the key is undefined.
 func (c *Config) get(key string) (string, error) {
-	if val, ok := c.values[key]; ok {
-		return val, nil
+	val, ok := c.lookup(key)
+	if !ok {
+		return "", keyNotFound(key)
+	}
+
+	return c.expand(key, val, nil)
+}
+
+// lookup returns the raw value for key, overridden by the environment, and
+// whether the key is found.
+func (c *Config) lookup(key string) (string, bool) {
+	if val, ok := c.lookupEnv(key); ok {
+		return val, true
 	}
+	val, ok := c.values[key]
 
-	return "", fmt.Errorf("No such key (%s)", key)
+	return val, ok
 }
 
 // set is the internal setter that only operates on strings.
 // set must update both the cached map of values and the raw string data.
+// New keys are added at the end of their section, or before the first
+// section if the key does not belong to a section.
+// With the CollectList policy all but the first definition of a duplicated
+// key are deleted, as the key only has one value after it is set.
 func (c *Config) set(key, value string) {
-	if _, ok := c.values[key]; !ok { // If new value add it to the raw data
-		c.raw = append(c.raw, fmt.Sprintf("%s = %s", key, value))
-	} else { // If existing value update it
-		for i, line := range c.raw {
-			matches := c.keyValuePattern.FindStringSubmatch(line)
-			if len(matches) == 3 {
-				if matches[1] == key {
-					c.raw[i] = strings.Replace(c.raw[i], matches[2], value, 1)
-				}
-			}
+	c.record(func(c *Config) {
+		c.set(key, value)
+	})
+	if indexes := c.indexes(key); c.duplicates == CollectList && len(indexes) > 1 {
+		for j := len(indexes) - 1; j > 0; j-- {
+			c.removeRaw(indexes[j], 1)
 		}
 	}
-	c.values[key] = value // Update the cached value
+
+	if i := c.index(key); i == -1 { // If new value add it to the raw data
+		section, name := c.sectionOf(key)
+		source := c.sectionSource(section)
+		i = c.insertIndex(section, source)
+		indent := ""
+		if _, _, ok := splitKeyValue(c.lineAt(i - 1)); ok {
+			indent = indentation(c.raw[i-1])
+		}
+		line := fmt.Sprintf("%s%s = %s", indent, name, value)
+		c.insertRaw(i, source, line)
+	} else { // If existing value update it
+		c.raw[i] = replaceValue(c.raw[i], value)
+	}
+	_, c.values[key], _ = splitKeyValue(c.raw[c.index(key)]) // Update the cached value
 }
 
 // parse is the internal parser that extracts all values and comments from
 // the input source.
 // Returns error if the parsing fails.
 func (c *Config) parse(r io.Reader) error {
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		line := scanner.Text()
-		c.raw = append(c.raw, line)
+	lines, err := readLines(r, "")
+	if err != nil {
+		return err
+	}
+
+	return c.parseLines(lines)
+}
 
-		tline := strings.TrimSpace(line)
-		if strings.HasPrefix(tline, "#") {
-			comment := strings.TrimSpace(strings.TrimLeft(tline, "#"))
-			c.comments = append(c.comments, comment)
-		} else if strings.Contains(tline, "=") {
-			parts := strings.SplitN(tline, "=", 2)
-			c.values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+// parseLines is the internal parser for lines that can come from several
+// files, see expandIncludes. A *ParseError returned has the path of the file
+// the line is from set.
+func (c *Config) parseLines(lines []sourceLine) error {
+	section := ""
+	for p := 0; p < len(lines); p++ {
+		line, n, path := lines[p].text, lines[p].n, lines[p].path
+		fail := func(err error) error {
+			if pe, ok := err.(*ParseError); ok {
+				pe.Path = path
+			}
+			return err
+		}
+
+		// Join the lines of a multi-line value into one entry, multi-line
+		// values do not continue into another file
+		entry := line
+		if _, value, ok := splitKeyValue(line); ok {
+			if delim, ok := heredocDelimiter(value); ok {
+				end := p + 1
+				for end < len(lines) && lines[end].path == path && strings.TrimSpace(lines[end].text) != delim {
+					end++
+				}
+				if end == len(lines) || lines[end].path != path {
+					reason := fmt.Sprintf("unterminated heredoc, missing %s", delim)
+					return fail(newParseError(line, n, strings.Index(line, "<<"), reason))
+				}
+				for _, l := range lines[p+1 : end+1] {
+					entry += "\n" + l.text
+				}
+				p = end
+			} else {
+				for continued(lines[p].text) && p+1 < len(lines) && lines[p+1].path == path {
+					p++
+					entry += "\n" + lines[p].text
+				}
+			}
+		}
+		c.raw = append(c.raw, entry)
+		c.sources = append(c.sources, path)
+
+		if c.strict && !lines[p].include {
+			if err := checkLine(entry, n); err != nil {
+				return fail(err)
+			}
+		}
+
+		if name, ok := sectionName(entry); ok {
+			section = name
+		} else if key, value, ok := splitKeyValue(entry); ok {
+			key = qualify(section, key)
+			if _, defined := c.values[key]; defined {
+				switch c.duplicates {
+				case FirstWins:
+					continue
+				case DuplicateError:
+					first := c.lineNumber(c.indexes(key)[0])
+					reason := fmt.Sprintf("duplicate key %s, first defined on line %d", key, first)
+					return fail(newParseError(line, n, len(indentation(line)), reason))
+				}
+			}
+			c.values[key] = value
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return err
+	if !containsOther(c.sources, "") {
+		c.sources = nil
 	}
 
 	return nil
 }
+
+// copyFrom replaces the contents and settings of c with a copy of those of
+// other. The recorded changes of c are kept as they are.
+// The caller must hold the lock of c if c is shared, and at least the read
+// lock of other.
+func (c *Config) copyFrom(other *Config) {
+	c.raw = append([]string(nil), other.raw...)
+	c.values = make(map[string]string, len(other.values))
+	for key, value := range other.values {
+		c.values[key] = value
+	}
+	c.strict = other.strict
+	c.duplicates = other.duplicates
+	c.envPrefix = other.envPrefix
+	c.layerPrefixes = other.layerPrefixes
+	c.sources = append([]string(nil), other.sources...)
+	if other.sources == nil {
+		c.sources = nil
+	}
+}
+
+// pending returns the contents of the config by the file they are from
+// together with the number of changes made to it, see forget. The contents
+// not from an included file are under the empty string.
+func (c *Config) pending() (map[string]string, int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entries := make(map[string][]string)
+	for i, entry := range c.raw {
+		source := c.sourceAt(i)
+		entries[source] = append(entries[source], entry)
+	}
+	contents := make(map[string]string, len(entries))
+	for source, e := range entries {
+		contents[source] = strings.Join(e, "\n")
+	}
+
+	return contents, len(c.changes)
+}
+
+// forget forgets the first n changes made to the config.
+func (c *Config) forget(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.changes = c.changes[n:]
+}
+
+// record saves a change made to the config, so that it can be applied again
+// to another config. Changes are only saved for the config of a ConfigFile,
+// which forgets them when they are persisted.
+func (c *Config) record(change func(*Config)) {
+	if c.journal {
+		c.changes = append(c.changes, change)
+	}
+}
+
+// index returns the index in the raw data of the line defining the value of
+// key. For a duplicated key that is the first or the last definition
+// depending on the DuplicatePolicy.
+// Returns -1 if the key is not defined.
+func (c *Config) index(key string) int {
+	indexes := c.indexes(key)
+	if len(indexes) == 0 {
+		return -1
+	}
+	if c.duplicates == FirstWins {
+		return indexes[0]
+	}
+
+	return indexes[len(indexes)-1]
+}
+
+// indexes returns the indexes in the raw data of all lines defining key.
+func (c *Config) indexes(key string) []int {
+	indexes := make([]int, 0, 1)
+	c.scan(func(i int, section string) bool {
+		k, _, ok := splitKeyValue(c.raw[i])
+		if ok && qualify(section, k) == key {
+			indexes = append(indexes, i)
+		}
+		return true
+	})
+
+	return indexes
+}
+
+// lineNumber returns the line number, starting at 1, of the line at index i
+// in the raw data, counted in the file the line is from.
+func (c *Config) lineNumber(i int) int {
+	n := 1
+	for j, entry := range c.raw[:i] {
+		if c.sourceAt(j) == c.sourceAt(i) {
+			n += strings.Count(entry, "\n") + 1
+		}
+	}
+
+	return n
+}
+
+// sourceAt returns the file the line at index i in the raw data is from, or
+// an empty string if it is not from an included file.
+func (c *Config) sourceAt(i int) string {
+	if i < 0 || i >= len(c.sources) {
+		return ""
+	}
+
+	return c.sources[i]
+}
+
+// insertRaw inserts entries from the file source at index i in the raw data.
+func (c *Config) insertRaw(i int, source string, entries ...string) {
+	c.raw = append(c.raw[:i], append(append([]string(nil), entries...), c.raw[i:]...)...)
+	if c.sources == nil && source == "" {
+		return
+	}
+	if c.sources == nil {
+		c.sources = make([]string, len(c.raw)-len(entries))
+	}
+	sources := make([]string, len(entries))
+	for j := range sources {
+		sources[j] = source
+	}
+	c.sources = append(c.sources[:i], append(sources, c.sources[i:]...)...)
+}
+
+// removeRaw removes n entries at index i from the raw data.
+func (c *Config) removeRaw(i, n int) {
+	c.raw = append(c.raw[:i], c.raw[i+n:]...)
+	if c.sources != nil {
+		c.sources = append(c.sources[:i], c.sources[i+n:]...)
+	}
+}
+
+// containsOther reports whether any of list is not s.
+func containsOther(list []string, s string) bool {
+	for _, v := range list {
+		if v != s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lineAt returns the line at index i in the raw data, or an empty string if
+// i is out of range.
+func (c *Config) lineAt(i int) string {
+	if i < 0 || i >= len(c.raw) {
+		return ""
+	}
+
+	return c.raw[i]
+}
+
+// scan calls fn for each line in the raw data together with the name of the
+// section the line belongs to. A section header belongs to its own section.
+// Scanning stops if fn returns false.
+func (c *Config) scan(fn func(i int, section string) bool) {
+	section := ""
+	for i, line := range c.raw {
+		if name, ok := sectionName(line); ok {
+			section = name
+		}
+		if !fn(i, section) {
+			return
+		}
+	}
+}
+
+// sectionOf returns the longest defined section that key belongs to and the
+// name of the key within that section.
+// The section is empty if key does not belong to any defined section.
+func (c *Config) sectionOf(key string) (section, name string) {
+	for _, s := range c.sections() {
+		if strings.HasPrefix(key, s+".") && len(s) > len(section) {
+			section = s
+		}
+	}
+	if section == "" {
+		return "", key
+	}
+
+	return section, key[len(section)+1:]
+}
+
+// insertIndex returns the index in the raw data where a new key in section
+// should be inserted. That is after the last key or header in the section.
+// Keys that are not in a section are inserted before the comment of the first
+// section header, or at the end if there are no sections.
+// Only lines from the file source are considered, see sourceAt, and the
+// include directives in it end the keys that are not in a section.
+func (c *Config) insertIndex(section, source string) int {
+	index, header, last := -1, -1, -1
+	c.scan(func(i int, s string) bool {
+		if c.sourceAt(i) != source {
+			return true
+		}
+		last = i
+		if _, ok := sectionName(c.raw[i]); ok {
+			if header == -1 {
+				header = i
+			}
+			if s == section {
+				index = i
+			}
+		} else if _, _, ok := splitKeyValue(c.raw[i]); ok && s == section {
+			index = i
+		} else if _, _, ok := includeDirective(c.raw[i]); ok && c.sources != nil && header == -1 {
+			header = i
+		}
+		return true
+	})
+	if index != -1 {
+		return index + 1
+	}
+	if section == "" && header != -1 {
+		return c.commentStart(header)
+	}
+	if c.sources != nil && last != -1 {
+		return last + 1
+	}
+
+	return len(c.raw)
+}
+
+// sectionSource returns the file a new key in section is written to, see
+// sourceAt. That is the file of the last header of the section, keys not in a
+// section are written to the including file.
+func (c *Config) sectionSource(section string) string {
+	if section == "" {
+		return ""
+	}
+	source := ""
+	c.scan(func(i int, s string) bool {
+		if name, ok := sectionName(c.raw[i]); ok && name == section {
+			source = c.sourceAt(i)
+		}
+		return true
+	})
+
+	return source
+}
+
+// sections returns the names of all sections in the order they are defined.
+func (c *Config) sections() []string {
+	sections := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, line := range c.raw {
+		if name, ok := sectionName(line); ok && !seen[name] {
+			seen[name] = true
+			sections = append(sections, name)
+		}
+	}
+
+	return sections
+}
+
+// commentStart returns the index in the raw data of the first line in the
+// comment block directly above the line at index i.
+// Returns i if there is no comment attached to the line.
+func (c *Config) commentStart(i int) int {
+	for i > 0 && isComment(c.raw[i-1]) {
+		i--
+	}
+
+	return i
+}
+
+// indentation returns the leading whitespace of line.
+func indentation(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// isComment reports whether line is a comment.
+func isComment(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+// commentText returns the text of the comment line without the leading #
+// and surrounding whitespace.
+func commentText(line string) string {
+	return strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+}
+
+// replaceValue returns the key value pair line with the value replaced by
+// value. The whitespace around the value is preserved.
+func replaceValue(line, value string) string {
+	eq := strings.Index(line, "=")
+	rest := line[eq+1:]
+	if strings.TrimSpace(rest) == "" {
+		return line[:eq+1] + " " + value
+	}
+	lead := indentation(rest)
+	trail := rest[len(strings.TrimRight(rest, " \t")):]
+
+	return line[:eq+1] + lead + value + trail
+}
+
+// sectionName returns the name of the section if line is a section header.
+// ok is false if the line is not a section header.
+func sectionName(line string) (name string, ok bool) {
+	tline := strings.TrimSpace(line)
+	if len(tline) < 2 || tline[0] != '[' || tline[len(tline)-1] != ']' {
+		return "", false
+	}
+
+	return strings.TrimSpace(tline[1 : len(tline)-1]), true
+}
+
+// qualify returns the full name of key in section.
+func qualify(section, key string) string {
+	if section == "" {
+		return key
+	}
+
+	return section + "." + key
+}
+
+// checkLine validates the syntax of the entry line, starting on the n:th line
+// in the source.
+// Returns a *ParseError describing the first problem found.
+func checkLine(line string, n int) error {
+	first := strings.SplitN(line, "\n", 2)[0]
+	tline := strings.TrimSpace(first)
+	if tline == "" || isComment(tline) {
+		return nil
+	}
+
+	indent := len(indentation(first))
+	if strings.HasPrefix(tline, "[") {
+		if !strings.HasSuffix(tline, "]") {
+			return newParseError(first, n, len(first), "missing ']'")
+		}
+		if name, _ := sectionName(tline); name == "" {
+			return newParseError(first, n, indent, "missing section name")
+		}
+		return nil
+	}
+
+	eq := strings.Index(first, "=")
+	switch {
+	case eq == -1:
+		return newParseError(first, n, indent, "missing '='")
+	case strings.TrimSpace(first[:eq]) == "":
+		return newParseError(first, n, eq, "missing key")
+	case strings.TrimSpace(first[eq+1:]) == "":
+		return newParseError(first, n, eq+1, "missing value")
+	}
+	if isHeredoc(line) {
+		return nil
+	}
+
+	start := eq + 1 + len(indentation(first[eq+1:]))
+	_, value, _ := splitKeyValue(line)
+	if offset, reason := checkValue(value); offset != -1 {
+		// Problems on continuation lines are reported at the start of the value
+		if start+offset > len(strings.TrimRight(first, "\\")) {
+			offset = 0
+		}
+		return newParseError(first, n, start+offset, reason)
+	}
+
+	return nil
+}
+
+// splitKeyValue splits the entry line into its key and value.
+// The value of a multi-line entry is returned as a single line value.
+// ok is false if the line is not a key value pair.
+func splitKeyValue(line string) (key, value string, ok bool) {
+	tline := strings.TrimSpace(line)
+	if _, header := sectionName(tline); header {
+		return "", "", false
+	}
+	first := strings.SplitN(tline, "\n", 2)[0]
+	if isComment(first) || !strings.Contains(first, "=") {
+		return "", "", false
+	}
+	parts := strings.SplitN(tline, "=", 2)
+	key, value = strings.TrimSpace(parts[0]), parts[1]
+
+	if lines := strings.Split(value, "\n"); len(lines) > 1 {
+		if _, ok := heredocDelimiter(strings.TrimSpace(lines[0])); ok {
+			// The value is stored as a quoted value with the body escaped
+			body := strings.Join(lines[1:len(lines)-1], "\n")
+			return key, `"` + escape(body) + `"`, true
+		}
+		for i := range lines {
+			if i > 0 {
+				lines[i] = strings.TrimLeft(lines[i], " \t")
+			}
+			if i < len(lines)-1 {
+				lines[i] = lines[i][:len(lines[i])-1]
+			}
+		}
+		value = strings.Join(lines, "")
+	}
+
+	return key, strings.TrimSpace(value), true
+}
@@ -1,47 +1,520 @@
 package cfg
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 )
 
 // ConfigFile is a utility type that can load and save config to a file.
+// The config contains the values of all files included by the file, and the
+// values are saved to the file they are defined in.
+// A ConfigFile is safe for concurrent use by multiple goroutines.
 type ConfigFile struct {
-	path string
+	mu        sync.Mutex // Guards the files and the fields below
+	path      string
+	options   []Option
+	states    map[string]fileState // By included path, empty for the file
+	callbacks []ChangeFunc
 	*Config
 }
 
+// ChangeFunc is called when a ConfigFile is reloaded with changed contents.
+// old contains the config before the reload and new the config after.
+// changedKeys are the keys that were added, removed or got a new value,
+// in sorted order.
+type ChangeFunc func(old, new *Config, changedKeys []string)
+
+// fileState is the state of the file when it was last read or written.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+	newline bool // The file ends with a line break
+}
+
 // NewConfigFile returns a new ConfigFile with the parsed data in
 // the file at path. Returns an error if the file can't be read or
 // if the parsing of the config fails.
-func NewConfigFile(path string) (*ConfigFile, error) {
-	f, err := os.OpenFile(path, os.O_RDONLY, 0644)
+// Files included with the include and include_if_exists directives are read
+// too, a *ParseError is returned for missing files and include cycles.
+// A *ParseError returned has the path of the file set.
+func NewConfigFile(path string, options ...Option) (*ConfigFile, error) {
+	c, states, err := loadFile(path, options)
 	if err != nil {
-		return nil, fmt.Errorf("cfg: could not open file: %s", err)
+		return nil, err
+	}
+	c.journal = true
+
+	return &ConfigFile{path: path, options: options, states: states, Config: c}, nil
+}
+
+// Origin returns the path of the file key is defined in, which is the path
+// of the ConfigFile or of an included file, and the line number of the
+// definition in that file.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+func (c *ConfigFile) Origin(key string) (path string, line int, err error) {
+	c.Config.mu.RLock()
+	defer c.Config.mu.RUnlock()
+
+	i := c.index(key)
+	if i == -1 {
+		return "", 0, keyNotFound(key)
 	}
-	c, err := NewConfigFromReader(f)
+
+	return c.filePath(c.sourceAt(i)), c.lineNumber(i), nil
+}
+
+// Modified reports whether the contents of the file, or of any included file,
+// have changed since they were read or last written by c. A removed file is
+// reported as modified.
+func (c *ConfigFile) Modified() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.modified()
+}
+
+// modified is the internal version of Modified.
+func (c *ConfigFile) modified() (bool, error) {
+	for source, state := range c.states {
+		data, err := ioutil.ReadFile(c.filePath(source))
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("cfg: could not read file: %s", err)
+		}
+		if sha256.Sum256(data) != state.hash {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Rebase reads the file again and reapplies all changes made to c since it
+// was read or last written, so that c can be persisted without discarding
+// changes made to the file by others.
+// Changes made by c win over changes made to the file for the same key.
+// Returns error if the file can't be read or if the parsing fails.
+func (c *ConfigFile) Rebase() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fresh, states, err := loadFile(c.path, c.options)
 	if err != nil {
-		return nil, fmt.Errorf("cfg: could not parse file: %s", err)
+		return err
 	}
-	err = f.Close()
+
+	c.Config.mu.Lock()
+	defer c.Config.mu.Unlock()
+	for _, change := range c.changes {
+		change(fresh)
+	}
+	c.Config.copyFrom(fresh)
+	c.states = states
+
+	return nil
+}
+
+// Reload reads and parses the file again, replacing the config.
+// Any changes made to the config since it was read or last written are
+// discarded. If the contents of the file have changed all functions registered
+// with OnChange are called.
+// Returns error if the file can't be read or if the parsing fails, the config
+// is left unchanged in that case.
+func (c *ConfigFile) Reload() error {
+	return c.reload(false)
+}
+
+// reload is the internal version of Reload. If rebase is true the changes
+// made to the config are reapplied to the new contents and kept, as for
+// Rebase, instead of being discarded.
+func (c *ConfigFile) reload(rebase bool) error {
+	c.mu.Lock()
+	fresh, states, err := loadFile(c.path, c.options)
 	if err != nil {
-		return nil, fmt.Errorf("cfg: could not close file: %s", err)
+		c.mu.Unlock()
+		return err
+	}
+
+	old := &Config{}
+	c.Config.mu.Lock()
+	old.copyFrom(c.Config)
+	if rebase {
+		for _, change := range c.changes {
+			change(fresh)
+		}
+	} else {
+		c.Config.changes = nil
+	}
+	c.Config.copyFrom(fresh)
+	c.Config.mu.Unlock()
+
+	changed := len(states) != len(c.states)
+	for source, state := range states {
+		if old, ok := c.states[source]; !ok || old.hash != state.hash {
+			changed = true
+		}
+	}
+	c.states = states
+	callbacks := append([]ChangeFunc(nil), c.callbacks...)
+	c.mu.Unlock()
+
+	// Call the functions without holding any locks, so they can use c
+	if changed {
+		keys := changedKeys(old, fresh)
+		for _, fn := range callbacks {
+			fn(old, c.Config, keys)
+		}
 	}
 
-	return &ConfigFile{path: path, Config: c}, nil
+	return nil
+}
+
+// OnChange registers fn to be called when the config is reloaded with
+// changed contents, see Reload and Watch.
+func (c *ConfigFile) OnChange(fn ChangeFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.callbacks = append(c.callbacks, fn)
+}
+
+// Watch polls the file, and all included files, every interval and reloads
+// the config when the modification time or size of a file has changed, see
+// Reload. Changes made to the config that are not yet persisted are kept and
+// reapplied to the new contents, see Rebase.
+// If the file can't be read or parsed the current config is kept and the
+// reload is tried again at the next interval.
+// Watch blocks until ctx is done and then returns the error from ctx.
+// An error is returned at once if interval is not positive.
+func (c *ConfigFile) Watch(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("cfg: non-positive watch interval %s", interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if c.changedOnDisk() {
+				// Errors are retried at the next interval
+				_ = c.reload(true)
+			}
+		}
+	}
+}
+
+// changedOnDisk reports whether the modification time or size of any of the
+// files has changed since they were read or last written. Files that can not
+// be stat'ed are not reported.
+func (c *ConfigFile) changedOnDisk() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for source, state := range c.states {
+		info, err := os.Stat(c.filePath(source))
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(state.modTime) || info.Size() != state.size {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Persist saves all configured values to the file.
+// Values defined in an included file are saved to that file. New keys are
+// saved to the file, unless the header of their section is in an included
+// file. Included files are only written if their contents have changed, and
+// keep their final line break.
+// The contents of each file are written to a temporary file in the same
+// directory that is synced to disk and then renamed over the file, so the file
+// is never left partially written. The mode of the existing file is preserved,
+// and the owner and group if the process is permitted to change them,
+// otherwise the owner may change to that of the process. Use PersistInPlace
+// if the file can not be replaced by a rename.
+// If a file has been modified by someone else since it was read
+// ErrModifiedExternally is returned and the files are left untouched, use
+// Rebase to apply the changes to the current file contents.
 // Returns error if something goes wrong.
 func (c *ConfigFile) Persist() error {
-	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.persist(replaceFile)
+}
+
+// replaceFile writes data to a temporary file and renames it over the file at
+// path, see Persist.
+func replaceFile(path, data string) error {
+	mode := os.FileMode(0644)
+	info, err := os.Stat(path)
+	if err == nil {
+		// Replace the target of a symbolic link, not the link itself
+		if path, err = filepath.EvalSymlinks(path); err != nil {
+			return fmt.Errorf("cfg: could not resolve file: %s", err)
+		}
+		mode = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("cfg: could not stat file: %s", err)
+	}
+
+	dir, name := filepath.Split(path)
+	f, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return fmt.Errorf("cfg: could not create temporary file: %s", err)
+	}
+	tmp := f.Name()
+	err = writeSynced(f, data, mode, info)
+	if err == nil {
+		err = os.Rename(tmp, path)
+		if err != nil {
+			err = fmt.Errorf("cfg: could not replace file: %s", err)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// PersistInPlace saves all configured values to the file by truncating and
+// writing to it directly. Unlike Persist the file can be left partially
+// written if writing fails, but it works where the file can not be replaced,
+// eg. a file bind mounted into a container.
+// Like Persist ErrModifiedExternally is returned if the file has been
+// modified by someone else since it was read.
+// Returns error if something goes wrong.
+func (c *ConfigFile) PersistInPlace() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.persist(writeInPlace)
+}
+
+// writeInPlace truncates the file at path and writes data to it, see
+// PersistInPlace.
+func writeInPlace(path, data string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("cfg: could not open file: %s", err)
 	}
-	_, err = f.WriteString(c.String())
+	_, err = f.WriteString(data)
+	if err != nil {
+		return fmt.Errorf("cfg: could not write file: %s", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("cfg: could not close file: %s", err)
+	}
+
+	return nil
+}
+
+// persist saves the contents of the config with write to the file and to the
+// included files whose contents have changed.
+func (c *ConfigFile) persist(write func(path, data string) error) error {
+	if err := c.checkModified(); err != nil {
+		return err
+	}
+	contents, n := c.Config.pending()
+
+	sources := make([]string, 0, len(c.states))
+	for source := range c.states {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	written := make(map[string]string, len(sources))
+	for _, source := range sources {
+		data := contents[source]
+		if source != "" {
+			// Included files keep their final line break, and are only
+			// written if changed
+			if c.states[source].newline {
+				data += "\n"
+			}
+			if sha256.Sum256([]byte(data)) == c.states[source].hash {
+				continue
+			}
+		}
+		if err := write(c.filePath(source), data); err != nil {
+			// Keep the state of the files already written
+			c.persisted(written, 0)
+			return err
+		}
+		written[source] = data
+	}
+
+	return c.persisted(written, n)
+}
+
+// checkModified returns ErrModifiedExternally if the file, or an included
+// file, has been modified since it was read or last written.
+func (c *ConfigFile) checkModified() error {
+	modified, err := c.modified()
 	if err != nil {
+		return err
+	}
+	if modified {
+		return ErrModifiedExternally
+	}
+
+	return nil
+}
+
+// persisted records the state of the files after the data by included path
+// has been written to them. The first n changes are now in the files, so they
+// are forgotten.
+func (c *ConfigFile) persisted(written map[string]string, n int) error {
+	for source, data := range written {
+		info, err := os.Stat(c.filePath(source))
+		if err != nil {
+			return fmt.Errorf("cfg: could not stat file: %s", err)
+		}
+		c.states[source] = newFileState(info, []byte(data))
+	}
+	c.Config.forget(n)
+
+	return nil
+}
+
+// filePath returns the path of the file with the included path source, see
+// Config.sourceAt.
+func (c *ConfigFile) filePath(source string) string {
+	if source == "" {
+		return c.path
+	}
+
+	return source
+}
+
+// loadFile reads and parses the file at path and all files it includes.
+// Returns the parsed config and the states of the files by included path,
+// with the state of the file at path under the empty string.
+func loadFile(path string, options []Option) (*Config, map[string]fileState, error) {
+	data, state, err := readFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines, err := readLines(bytes.NewReader(data), "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("cfg: could not parse file: %s", err)
+	}
+	states := map[string]fileState{"": state}
+	lines, err = expandIncludes(lines, path, states)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := NewConfig(options...)
+	if err := c.parseLines(lines); err != nil {
+		if pe, ok := err.(*ParseError); ok {
+			if pe.Path == "" {
+				pe.Path = path
+			}
+			return nil, nil, pe
+		}
+		return nil, nil, fmt.Errorf("cfg: could not parse file: %s", err)
+	}
+
+	return c, states, nil
+}
+
+// readFile returns the contents and the state of the file at path.
+func readFile(path string) ([]byte, fileState, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, fileState{}, fmt.Errorf("cfg: could not open file: %s", err)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, fileState{}, fmt.Errorf("cfg: could not read file: %s", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fileState{}, fmt.Errorf("cfg: could not stat file: %s", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return nil, fileState{}, fmt.Errorf("cfg: could not close file: %s", err)
+	}
+
+	return data, newFileState(info, data), nil
+}
+
+// changedKeys returns the sorted keys that are only defined in one of old and
+// new, or that have different values.
+func changedKeys(old, new *Config) []string {
+	keys := make([]string, 0)
+	for key, value := range old.values {
+		if v, ok := new.values[key]; !ok || v != value {
+			keys = append(keys, key)
+		}
+	}
+	for key := range new.values {
+		if _, ok := old.values[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// newFileState returns the state of a file with info and contents data.
+func newFileState(info os.FileInfo, data []byte) fileState {
+	return fileState{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		hash:    sha256.Sum256(data),
+		newline: bytes.HasSuffix(data, []byte("\n")),
+	}
+}
+
+// writeSynced writes data to f and syncs it to disk, sets the mode and the
+// owner from info, if not nil, and closes f.
+func writeSynced(f *os.File, data string, mode os.FileMode, info os.FileInfo) error {
+	_, err := f.WriteString(data)
+	if err != nil {
+		f.Close()
 		return fmt.Errorf("cfg: could not write file: %s", err)
 	}
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("cfg: could not sync file: %s", err)
+	}
+	err = f.Chmod(mode)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("cfg: could not set file mode: %s", err)
+	}
+	if info != nil {
+		err = chown(f, info)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("cfg: could not set file owner: %s", err)
+		}
+	}
 	err = f.Close()
 	if err != nil {
 		return fmt.Errorf("cfg: could not close file: %s", err)
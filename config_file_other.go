@@ -0,0 +1,11 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package cfg
+
+import "os"
+
+// chown is a no-op as file ownership is not available as uid and gid.
+func chown(f *os.File, info os.FileInfo) error {
+	return nil
+}
@@ -1,8 +1,15 @@
 package cfg_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/walle/cfg"
 )
@@ -115,3 +122,475 @@ func Test_ConfigFilePath(t *testing.T) {
 		t.Errorf("Expected %s, got %s\n", path, configFile.Path())
 	}
 }
+
+func Test_ConfigFileParseError(t *testing.T) {
+	f, err := ioutil.TempFile("", "cfg-test")
+	if err != nil {
+		t.Errorf("Error creating tmp file: %s\n", err)
+	}
+
+	path := f.Name()
+	f.WriteString(configContents + "\nmissing equals")
+	f.Close()
+
+	_, err = cfg.NewConfigFile(path, cfg.Strict())
+	pe, ok := err.(*cfg.ParseError)
+	if !ok {
+		t.Fatalf("Expected *ParseError got %v\n", err)
+	}
+	if pe.Path != path {
+		t.Errorf("Expected %s got %s\n", path, pe.Path)
+	}
+	if pe.Line != 14 {
+		t.Errorf("Expected %v got %v\n", 14, pe.Line)
+	}
+
+	expected := "cfg: " + path + ": line 14, column 1: missing '='"
+	if pe.Error() != expected {
+		t.Errorf("Expected %q got %q\n", expected, pe.Error())
+	}
+}
+
+func Test_ConfigFilePersistAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfg-test")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.cfg")
+	err = ioutil.WriteFile(path, []byte(configContents), 0600)
+	if err != nil {
+		t.Fatalf("Error writing tmp file: %s\n", err)
+	}
+
+	configFile, err := cfg.NewConfigFile(path)
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+	configFile.SetInt("answer", 314)
+
+	err = configFile.Persist()
+	if err != nil {
+		t.Errorf("Error persisting config: %s\n", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Error reading file info: %s\n", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode %v got %v\n", os.FileMode(0600), info.Mode().Perm())
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Error reading tmp dir: %s\n", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("Expected only the config file in dir got %d files\n", len(files))
+	}
+
+	b, _ := ioutil.ReadFile(path)
+	if string(b) != configFile.String() {
+		t.Errorf("Expected %q got %q\n", configFile.String(), string(b))
+	}
+}
+
+func Test_ConfigFilePersistInPlace(t *testing.T) {
+	f, err := ioutil.TempFile("", "cfg-test")
+	if err != nil {
+		t.Errorf("Error creating tmp file: %s\n", err)
+	}
+
+	path := f.Name()
+	f.WriteString(configContents)
+	f.Close()
+	defer os.Remove(path)
+
+	configFile, err := cfg.NewConfigFile(path)
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+	configFile.SetInt("answer", 314)
+
+	err = configFile.PersistInPlace()
+	if err != nil {
+		t.Errorf("Error persisting config: %s\n", err)
+	}
+
+	c2, err := cfg.NewConfigFile(path)
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+
+	a2, _ := c2.GetInt("answer")
+	if a2 != 314 {
+		t.Errorf("Expected %v got %v\n", 314, a2)
+	}
+}
+
+func Test_ConfigFileModifiedExternally(t *testing.T) {
+	f, err := ioutil.TempFile("", "cfg-test")
+	if err != nil {
+		t.Errorf("Error creating tmp file: %s\n", err)
+	}
+
+	path := f.Name()
+	f.WriteString(configContents)
+	f.Close()
+	defer os.Remove(path)
+
+	configFile, err := cfg.NewConfigFile(path)
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+	configFile.SetInt("answer", 314)
+	configFile.Unset("is_active")
+
+	modified, err := configFile.Modified()
+	if err != nil || modified {
+		t.Errorf("Expected file not to be modified got %v, %v\n", modified, err)
+	}
+
+	err = ioutil.WriteFile(path, []byte(configContents+"\nadded = externally"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing tmp file: %s\n", err)
+	}
+
+	err = configFile.Persist()
+	if !errors.Is(err, cfg.ErrModifiedExternally) {
+		t.Errorf("Expected ErrModifiedExternally got %v\n", err)
+	}
+	err = configFile.PersistInPlace()
+	if !errors.Is(err, cfg.ErrModifiedExternally) {
+		t.Errorf("Expected ErrModifiedExternally got %v\n", err)
+	}
+
+	err = configFile.Rebase()
+	if err != nil {
+		t.Errorf("Error rebasing config: %s\n", err)
+	}
+	err = configFile.Persist()
+	if err != nil {
+		t.Errorf("Error persisting config: %s\n", err)
+	}
+
+	c2, err := cfg.NewConfigFile(path)
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+	a2, _ := c2.GetInt("answer")
+	if a2 != 314 {
+		t.Errorf("Expected %v got %v\n", 314, a2)
+	}
+	added, _ := c2.GetString("added")
+	if added != "externally" {
+		t.Errorf("Expected %q got %q\n", "externally", added)
+	}
+	_, err = c2.GetBool("is_active")
+	if !errors.Is(err, cfg.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound got %v\n", err)
+	}
+
+	configFile.SetInt("answer", 42)
+	err = configFile.Persist()
+	if err != nil {
+		t.Errorf("Error persisting config after persist: %s\n", err)
+	}
+}
+
+func Test_ConfigFileReload(t *testing.T) {
+	f, err := ioutil.TempFile("", "cfg-test")
+	if err != nil {
+		t.Errorf("Error creating tmp file: %s\n", err)
+	}
+
+	path := f.Name()
+	f.WriteString(configContents)
+	f.Close()
+	defer os.Remove(path)
+
+	configFile, err := cfg.NewConfigFile(path)
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+
+	calls := 0
+	configFile.OnChange(func(old, new *cfg.Config, changedKeys []string) {
+		calls++
+		if fmt.Sprint(changedKeys) != "[added answer is_active]" {
+			t.Errorf("Expected %v got %v\n", "[added answer is_active]", changedKeys)
+		}
+		a, _ := old.GetInt("answer")
+		if a != 42 {
+			t.Errorf("Expected %v got %v\n", 42, a)
+		}
+		a, _ = new.GetInt("answer")
+		if a != 314 {
+			t.Errorf("Expected %v got %v\n", 314, a)
+		}
+	})
+
+	err = configFile.Reload()
+	if err != nil {
+		t.Errorf("Error reloading config: %s\n", err)
+	}
+	if calls != 0 {
+		t.Errorf("Expected no calls for unchanged file got %d\n", calls)
+	}
+
+	contents := strings.Replace(configContents, "answer = 42", "answer = 314", 1)
+	contents = strings.Replace(contents, "is_active = true", "added = true", 1)
+	err = ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("Error writing tmp file: %s\n", err)
+	}
+
+	err = configFile.Reload()
+	if err != nil {
+		t.Errorf("Error reloading config: %s\n", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call got %d\n", calls)
+	}
+
+	a, _ := configFile.GetInt("answer")
+	if a != 314 {
+		t.Errorf("Expected %v got %v\n", 314, a)
+	}
+}
+
+func Test_ConfigFileWatch(t *testing.T) {
+	f, err := ioutil.TempFile("", "cfg-test")
+	if err != nil {
+		t.Errorf("Error creating tmp file: %s\n", err)
+	}
+
+	path := f.Name()
+	f.WriteString(configContents)
+	f.Close()
+	defer os.Remove(path)
+
+	configFile, err := cfg.NewConfigFile(path)
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+
+	changed := make(chan []string, 1)
+	configFile.OnChange(func(old, new *cfg.Config, changedKeys []string) {
+		changed <- changedKeys
+	})
+	configFile.SetInt("answer", 314)
+
+	if err := configFile.Watch(context.Background(), 0); err == nil {
+		t.Errorf("Expected error for interval 0\n")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- configFile.Watch(ctx, 10*time.Millisecond)
+	}()
+
+	err = ioutil.WriteFile(path, []byte(configContents+"\nadded = true"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing tmp file: %s\n", err)
+	}
+
+	select {
+	case keys := <-changed:
+		if fmt.Sprint(keys) != "[added]" {
+			t.Errorf("Expected %v got %v\n", "[added]", keys)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for change\n")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected %v got %v\n", context.Canceled, err)
+	}
+
+	// The change not yet persisted is kept
+	if a, _ := configFile.GetInt("answer"); a != 314 {
+		t.Errorf("Expected %v got %v\n", 314, a)
+	}
+	if err := configFile.Persist(); err != nil {
+		t.Errorf("Error persisting config: %s\n", err)
+	}
+	c2, _ := cfg.NewConfigFile(path)
+	a2, _ := c2.GetInt("answer")
+	added, _ := c2.GetBool("added")
+	if a2 != 314 || !added {
+		t.Errorf("Expected %v and %v got %v and %v\n", 314, true, a2, added)
+	}
+}
+
+func Test_ConfigFileIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfg-test")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"app.cfg":        "name = app\ninclude base.cfg\n[db]\ninclude_if_exists conf.d/*.cfg\nuser = admin",
+		"base.cfg":       "# Base values\ntimeout = 5\n[log]\nlevel = info",
+		"conf.d/a.cfg":   "host = localhost\n",
+		"conf.d/b.cfg":   "port = 5432\n",
+		"conf.d/not.txt": "port = 1",
+	}
+	os.Mkdir(filepath.Join(dir, "conf.d"), 0755)
+	for name, contents := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatalf("Error writing tmp file: %s\n", err)
+		}
+	}
+	unchanged := filepath.Join(dir, "conf.d", "a.cfg")
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(unchanged, past, past); err != nil {
+		t.Fatalf("Error changing times of tmp file: %s\n", err)
+	}
+
+	path := filepath.Join(dir, "app.cfg")
+	configFile, err := cfg.NewConfigFile(path)
+	if err != nil {
+		t.Fatalf("Error parsing config: %s\n", err)
+	}
+
+	values := map[string]string{
+		"name":      "app",
+		"timeout":   "5",
+		"log.level": "info",
+		"db.host":   "localhost",
+		"db.port":   "5432",
+		"db.user":   "admin",
+	}
+	for key, expected := range values {
+		if v, _ := configFile.GetString(key); v != expected {
+			t.Errorf("Expected %s to be %q got %q\n", key, expected, v)
+		}
+	}
+
+	origins := []struct {
+		key  string
+		path string
+		line int
+	}{
+		{"name", path, 1},
+		{"log.level", filepath.Join(dir, "base.cfg"), 4},
+		{"db.port", filepath.Join(dir, "conf.d", "b.cfg"), 1},
+		{"db.user", path, 5},
+	}
+	for _, o := range origins {
+		p, line, err := configFile.Origin(o.key)
+		if err != nil {
+			t.Errorf("Error getting origin of %s: %s\n", o.key, err)
+		}
+		if p != o.path || line != o.line {
+			t.Errorf("Expected %s:%d for %s got %s:%d\n", o.path, o.line, o.key, p, line)
+		}
+	}
+	if _, _, err := configFile.Origin("missing"); !errors.Is(err, cfg.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound got %v\n", err)
+	}
+
+	configFile.SetInt("db.port", 5433)
+	configFile.SetString("log.format", "json")
+	configFile.SetString("new", "n")
+	configFile.SetString("db.password", "secret")
+	configFile.Unset("timeout")
+	err = configFile.Persist()
+	if err != nil {
+		t.Fatalf("Error persisting config: %s\n", err)
+	}
+
+	expected := map[string]string{
+		"app.cfg":      "name = app\nnew = n\ninclude base.cfg\n[db]\ninclude_if_exists conf.d/*.cfg\nuser = admin\npassword = secret",
+		"base.cfg":     "# Base values\n[log]\nlevel = info\nformat = json",
+		"conf.d/a.cfg": files["conf.d/a.cfg"],
+		"conf.d/b.cfg": "port = 5433\n",
+	}
+	for name, contents := range expected {
+		b, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		if string(b) != contents {
+			t.Errorf("Expected %s to be %q got %q\n", name, contents, string(b))
+		}
+	}
+	if info, err := os.Stat(unchanged); err != nil {
+		t.Errorf("Error reading tmp file: %s\n", err)
+	} else if !info.ModTime().Equal(past) {
+		t.Errorf("Expected %s to not be written got mtime %v\n", unchanged, info.ModTime())
+	}
+
+	modified, err := configFile.Modified()
+	if err != nil || modified {
+		t.Errorf("Expected not modified got %v, %v\n", modified, err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "conf.d", "a.cfg"), []byte("host = db"), 0644)
+	if err := configFile.Persist(); err != cfg.ErrModifiedExternally {
+		t.Errorf("Expected %v got %v\n", cfg.ErrModifiedExternally, err)
+	}
+}
+
+func Test_ConfigFileIncludeErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfg-test")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		files    map[string]string
+		expected string
+	}{
+		{
+			map[string]string{"a.cfg": "include b.cfg", "b.cfg": "x = 1\ninclude a.cfg"},
+			"b.cfg: line 2, column 9: include cycle a.cfg -> b.cfg -> a.cfg",
+		},
+		{
+			map[string]string{"a.cfg": "include b.cfg\ninclude b.cfg", "b.cfg": "x = 1"},
+			"a.cfg: line 2, column 9: file b.cfg is already included",
+		},
+		{
+			map[string]string{"a.cfg": "x = 1\ninclude missing.cfg"},
+			"a.cfg: line 2, column 9: could not include missing.cfg, no such file",
+		},
+		{
+			map[string]string{"a.cfg": "include b.cfg", "b.cfg": "x = <<EOF\n1"},
+			"b.cfg: line 1, column 5: unterminated heredoc, missing EOF",
+		},
+		{
+			map[string]string{"a.cfg": "include_if_exists missing.cfg\nx = 1"},
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		for name, contents := range test.files {
+			err = ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+			if err != nil {
+				t.Fatalf("Error writing tmp file: %s\n", err)
+			}
+		}
+
+		_, err := cfg.NewConfigFile(filepath.Join(dir, "a.cfg"))
+		if test.expected == "" {
+			if err != nil {
+				t.Errorf("Expected no error got %s\n", err)
+			}
+			continue
+		}
+		if _, ok := err.(*cfg.ParseError); !ok {
+			t.Errorf("Expected *ParseError got %v\n", err)
+			continue
+		}
+		expected := "cfg: " + strings.Replace(test.expected, ".cfg", "", -1)
+		got := strings.Replace(strings.Replace(err.Error(), dir+string(filepath.Separator), "", -1), ".cfg", "", -1)
+		if got != expected {
+			t.Errorf("Expected %q got %q\n", expected, got)
+		}
+	}
+}
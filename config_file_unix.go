@@ -0,0 +1,39 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package cfg
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// chown sets the owner of f to the owner in info, if it differs.
+// Not being permitted to change the owner is not an error, f then keeps the
+// owner of the process, so the owner may change. The group is still set if
+// the process is permitted to, eg. when it is a member of the group.
+func chown(f *os.File, info os.FileInfo) error {
+	want, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	current, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if have, ok := current.Sys().(*syscall.Stat_t); ok && have.Uid == want.Uid && have.Gid == want.Gid {
+		return nil
+	}
+
+	err = f.Chown(int(want.Uid), int(want.Gid))
+	if errors.Is(err, syscall.EPERM) {
+		// Keep at least the group, so its members can still read the file
+		err = f.Chown(-1, int(want.Gid))
+		if errors.Is(err, syscall.EPERM) {
+			return nil
+		}
+	}
+
+	return err
+}
@@ -1,12 +1,16 @@
 package cfg_test
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/walle/cfg"
 )
@@ -110,6 +114,56 @@ func Test_UndefinedKey(t *testing.T) {
 	}
 }
 
+func Test_ErrKeyNotFound(t *testing.T) {
+	config := newConfigFromFile("types", t)
+
+	_, err := config.GetInt("undefined")
+	if !errors.Is(err, cfg.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound got %v\n", err)
+	}
+
+	_, err = config.GetString("undefined")
+	if !errors.Is(err, cfg.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound got %v\n", err)
+	}
+
+	_, err = config.KeyComment("undefined")
+	if !errors.Is(err, cfg.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound got %v\n", err)
+	}
+
+	_, err = config.GetInt("string")
+	if errors.Is(err, cfg.ErrKeyNotFound) {
+		t.Errorf("Did not expect ErrKeyNotFound for malformed value\n")
+	}
+}
+
+func Test_ValueError(t *testing.T) {
+	config := newConfigFromFile("types", t)
+
+	_, err := config.GetInt("string")
+	var ve *cfg.ValueError
+	if !errors.As(err, &ve) {
+		t.Fatalf("Expected *ValueError got %v\n", err)
+	}
+	if ve.Key != "string" || ve.Value != "This is a string!" || ve.Type != "integer" {
+		t.Errorf("Unexpected error data %+v\n", ve)
+	}
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("Expected error to wrap strconv.ErrSyntax\n")
+	}
+
+	_, err = config.GetFloat("string")
+	if !errors.As(err, &ve) || ve.Type != "float" {
+		t.Errorf("Expected *ValueError for float got %v\n", err)
+	}
+
+	_, err = config.GetBool("string")
+	if !errors.As(err, &ve) || ve.Type != "boolean" {
+		t.Errorf("Expected *ValueError for boolean got %v\n", err)
+	}
+}
+
 func Test_Comments(t *testing.T) {
 	config := newConfigFromFile("comments", t)
 
@@ -273,6 +327,865 @@ func Test_WriteNewlines(t *testing.T) {
 	}
 }
 
+func Test_KeyComment(t *testing.T) {
+	config := newConfigFromFile("key_comments", t)
+
+	foo, err := config.KeyComment("foo")
+	if err != nil {
+		t.Errorf("Key foo not found: %s\n", err)
+	}
+	if foo != "The foo value\nspans two lines" {
+		t.Errorf("Expected %q got %q\n", "The foo value\nspans two lines", foo)
+	}
+
+	bar, err := config.KeyComment("bar")
+	if err != nil {
+		t.Errorf("Key bar not found: %s\n", err)
+	}
+	if bar != "" {
+		t.Errorf("Expected %q got %q\n", "", bar)
+	}
+
+	foobar, err := config.KeyComment("foobar")
+	if err != nil {
+		t.Errorf("Key foobar not found: %s\n", err)
+	}
+	if foobar != "Indented comment" {
+		t.Errorf("Expected %q got %q\n", "Indented comment", foobar)
+	}
+
+	_, err = config.KeyComment("undefined")
+	if err == nil {
+		t.Errorf("Expected not found error but got none")
+	}
+}
+
+func Test_SetKeyComment(t *testing.T) {
+	config := newConfigFromFile("key_comments", t)
+
+	golden := getGolden("key_comments.cfg", t)
+
+	if err := config.SetKeyComment("foo", "A new foo comment"); err != nil {
+		t.Errorf("Error setting comment: %s\n", err)
+	}
+	if err := config.SetKeyComment("bar", "Bar value"); err != nil {
+		t.Errorf("Error setting comment: %s\n", err)
+	}
+	if err := config.SetKeyComment("foobar", ""); err != nil {
+		t.Errorf("Error setting comment: %s\n", err)
+	}
+	config.SetWithComment("added", "value", "Added value")
+
+	if config.String() != golden {
+		t.Errorf("Expected %q got %q\n", golden, config.String())
+	}
+
+	if err := config.SetKeyComment("undefined", "comment"); err == nil {
+		t.Errorf("Expected not found error but got none")
+	}
+}
+
+func Test_KeyCommentRoundTrip(t *testing.T) {
+	b, err := ioutil.ReadFile("_testdata/key_comments.cfg")
+	if err != nil {
+		t.Errorf("Error reading test data: %s\n", err)
+	}
+	src := strings.TrimRight(string(b), "\n")
+
+	config, err := cfg.NewConfigFromReader(strings.NewReader(src))
+	if err != nil {
+		t.Errorf("Error creating config: %s\n", err)
+	}
+
+	if config.String() != src {
+		t.Errorf("Expected %q got %q\n", src, config.String())
+	}
+}
+
+func Test_Sections(t *testing.T) {
+	config := newConfigFromFile("sections", t)
+
+	sections := config.Sections()
+	expected := []string{"db", "server", "db.replica"}
+	if len(sections) != len(expected) {
+		t.Fatalf("Expected %v got %v\n", expected, sections)
+	}
+	for i := range expected {
+		if sections[i] != expected[i] {
+			t.Errorf("Expected %v got %v\n", expected, sections)
+		}
+	}
+
+	name, err := config.GetString("name")
+	if err != nil {
+		t.Errorf("Key name not found: %s\n", err)
+	}
+	if name != "global" {
+		t.Errorf("Expected %q got %q\n", "global", name)
+	}
+
+	dbPort, err := config.GetInt("db.port")
+	if err != nil {
+		t.Errorf("Key db.port not found: %s\n", err)
+	}
+	if dbPort != 5432 {
+		t.Errorf("Expected %v got %v\n", 5432, dbPort)
+	}
+
+	serverPort, err := config.GetInt("server.port")
+	if err != nil {
+		t.Errorf("Key server.port not found: %s\n", err)
+	}
+	if serverPort != 8080 {
+		t.Errorf("Expected %v got %v\n", 8080, serverPort)
+	}
+
+	replica, err := config.GetString("db.replica.host")
+	if err != nil {
+		t.Errorf("Key db.replica.host not found: %s\n", err)
+	}
+	if replica != "replica" {
+		t.Errorf("Expected %q got %q\n", "replica", replica)
+	}
+
+	_, err = config.GetString("port")
+	if err == nil {
+		t.Errorf("Expected not found error but got none")
+	}
+
+	comment, err := config.KeyComment("server.port")
+	if err != nil {
+		t.Errorf("Key server.port not found: %s\n", err)
+	}
+	if comment != "Port to listen on" {
+		t.Errorf("Expected %q got %q\n", "Port to listen on", comment)
+	}
+}
+
+func Test_SectionsUpdate(t *testing.T) {
+	config := newConfigFromFile("sections", t)
+
+	golden := getGolden("sections.cfg", t)
+
+	config.SetString("db.host", "example.com")
+	config.SetString("db.user", "admin")
+	config.Unset("db.port")
+	config.SetInt("server.timeout", 30)
+	config.SetInt("db.replica.port", 5433)
+	config.SetString("added", "global")
+
+	if config.String() != golden {
+		t.Errorf("Expected %q got %q\n", golden, config.String())
+	}
+
+	user, err := config.GetString("db.user")
+	if err != nil {
+		t.Errorf("Key db.user not found: %s\n", err)
+	}
+	if user != "admin" {
+		t.Errorf("Expected %q got %q\n", "admin", user)
+	}
+}
+
+func Test_Strict(t *testing.T) {
+	f, err := os.Open("_testdata/sections.cfg")
+	if err != nil {
+		t.Errorf("Error reading test data: %s\n", err)
+	}
+	defer f.Close()
+
+	_, err = cfg.NewConfigFromReader(f, cfg.Strict())
+	if err != nil {
+		t.Errorf("Expected no error but got: %s\n", err)
+	}
+}
+
+func Test_StrictParseError(t *testing.T) {
+	tests := []struct {
+		source string
+		line   int
+		column int
+		reason string
+	}{
+		{"foo = bar\n  bar", 2, 3, "missing '='"},
+		{"foo = bar\n\n = bar", 3, 2, "missing key"},
+		{"# comment\nfoo =   ", 2, 6, "missing value"},
+		{"[db\nfoo = bar", 1, 4, "missing ']'"},
+		{"[ ]", 1, 1, "missing section name"},
+		{"åäö", 1, 1, "missing '='"},
+		{"åäö =", 1, 6, "missing value"},
+	}
+
+	for _, test := range tests {
+		r := strings.NewReader(test.source)
+		_, err := cfg.NewConfigFromReader(r, cfg.Strict())
+		pe, ok := err.(*cfg.ParseError)
+		if !ok {
+			t.Errorf("Expected *ParseError for %q got %v\n", test.source, err)
+			continue
+		}
+		if pe.Line != test.line || pe.Column != test.column {
+			t.Errorf("Expected %d:%d got %d:%d\n", test.line, test.column, pe.Line, pe.Column)
+		}
+		if pe.Reason != test.reason {
+			t.Errorf("Expected %q got %q\n", test.reason, pe.Reason)
+		}
+		lines := strings.Split(test.source, "\n")
+		if pe.Text != lines[test.line-1] {
+			t.Errorf("Expected %q got %q\n", lines[test.line-1], pe.Text)
+		}
+	}
+}
+
+func Test_NotStrict(t *testing.T) {
+	config, err := cfg.NewConfigFromReader(strings.NewReader("foo\nbar =\nfoo = bar"))
+	if err != nil {
+		t.Errorf("Expected no error but got: %s\n", err)
+	}
+
+	foo, _ := config.GetString("foo")
+	if foo != "bar" {
+		t.Errorf("Expected %q got %q\n", "bar", foo)
+	}
+}
+
+func Test_Duplicates(t *testing.T) {
+	config := newConfigFromFile("duplicates", t)
+
+	duplicates := config.Duplicates()
+	if len(duplicates) != 2 {
+		t.Errorf("Expected 2 duplicated keys got %v\n", duplicates)
+	}
+	if fmt.Sprint(duplicates["foo"]) != "[3 5]" {
+		t.Errorf("Expected %v got %v\n", "[3 5]", duplicates["foo"])
+	}
+	if fmt.Sprint(duplicates["db.host"]) != "[8 10]" {
+		t.Errorf("Expected %v got %v\n", "[8 10]", duplicates["db.host"])
+	}
+}
+
+func Test_DuplicatePolicy(t *testing.T) {
+	tests := []struct {
+		policy cfg.DuplicatePolicy
+		foo    string
+		host   string
+	}{
+		{cfg.LastWins, "second", "replica"},
+		{cfg.FirstWins, "first", "primary"},
+		{cfg.CollectList, "second", "replica"},
+	}
+
+	for _, test := range tests {
+		f, err := os.Open("_testdata/duplicates.cfg")
+		if err != nil {
+			t.Errorf("Error reading test data: %s\n", err)
+		}
+		config, err := cfg.NewConfigFromReader(f, cfg.WithDuplicatePolicy(test.policy))
+		f.Close()
+		if err != nil {
+			t.Errorf("Error creating config: %s\n", err)
+		}
+
+		foo, _ := config.GetString("foo")
+		if foo != test.foo {
+			t.Errorf("Expected %q got %q\n", test.foo, foo)
+		}
+		host, _ := config.GetString("db.host")
+		if host != test.host {
+			t.Errorf("Expected %q got %q\n", test.host, host)
+		}
+
+		all, err := config.GetAll("foo")
+		if err != nil {
+			t.Errorf("Key foo not found: %s\n", err)
+		}
+		if fmt.Sprint(all) != "[first second]" {
+			t.Errorf("Expected %v got %v\n", "[first second]", all)
+		}
+	}
+}
+
+func Test_DuplicateError(t *testing.T) {
+	f, err := os.Open("_testdata/duplicates.cfg")
+	if err != nil {
+		t.Errorf("Error reading test data: %s\n", err)
+	}
+	defer f.Close()
+
+	_, err = cfg.NewConfigFromReader(f, cfg.WithDuplicatePolicy(cfg.DuplicateError))
+	pe, ok := err.(*cfg.ParseError)
+	if !ok {
+		t.Fatalf("Expected *ParseError got %v\n", err)
+	}
+	if pe.Line != 5 {
+		t.Errorf("Expected %v got %v\n", 5, pe.Line)
+	}
+	if pe.Reason != "duplicate key foo, first defined on line 3" {
+		t.Errorf("Unexpected reason %q\n", pe.Reason)
+	}
+}
+
+func Test_DuplicatesUpdate(t *testing.T) {
+	f, err := os.Open("_testdata/duplicates.cfg")
+	if err != nil {
+		t.Errorf("Error reading test data: %s\n", err)
+	}
+	defer f.Close()
+
+	config, err := cfg.NewConfigFromReader(f, cfg.WithDuplicatePolicy(cfg.CollectList))
+	if err != nil {
+		t.Errorf("Error creating config: %s\n", err)
+	}
+
+	golden := getGolden("duplicates.cfg", t)
+
+	config.SetString("foo", "updated")
+	config.Unset("db.host")
+
+	if config.String() != golden {
+		t.Errorf("Expected %q got %q\n", golden, config.String())
+	}
+	if len(config.Duplicates()) != 0 {
+		t.Errorf("Expected no duplicates got %v\n", config.Duplicates())
+	}
+}
+
+func Test_Snapshot(t *testing.T) {
+	config := newConfigFromFile("read", t)
+
+	snapshot := config.Snapshot()
+	config.SetString("foo", "changed")
+	config.Unset("bar")
+
+	foo, _ := snapshot.GetString("foo")
+	if foo != "bar" {
+		t.Errorf("Expected %q got %q\n", "bar", foo)
+	}
+	bar, err := snapshot.GetString("bar")
+	if err != nil || bar != "foo" {
+		t.Errorf("Expected %q got %q, %v\n", "foo", bar, err)
+	}
+
+	snapshot.SetString("bar", "snapshot")
+	_, err = config.GetString("bar")
+	if !errors.Is(err, cfg.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound got %v\n", err)
+	}
+}
+
+func Test_Concurrent(t *testing.T) {
+	config := newConfigFromFile("sections", t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				config.SetInt(fmt.Sprintf("server.key%d", i), j)
+				config.SetWithComment("db.host", "localhost", "Host")
+				config.Unset(fmt.Sprintf("key%d", j))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				config.GetInt("db.port")
+				config.KeyComment("db.host")
+				_ = config.Snapshot().String()
+				cfg.UnmarshalFromConfig(config, &struct{ Name string }{})
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		v, err := config.GetInt(fmt.Sprintf("server.key%d", i))
+		if err != nil || v != 99 {
+			t.Errorf("Expected %v got %v, %v\n", 99, v, err)
+		}
+	}
+}
+
+func Test_Values(t *testing.T) {
+	config := newConfigFromFile("values", t)
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"bare", "foo bar"},
+		{"quoted", "  foo bar  "},
+		{"escapes", "tab\tbackslash\\quote\"unicode\u00e5\U0001f600"},
+		{"quoted_escapes", "say \"hi\"\n"},
+		{"unknown", "C:\\dir"},
+		{"inner", "foo \"bar\" baz"},
+		{"empty", ""},
+		{"spaced", "foo bar"},
+	}
+
+	for _, test := range tests {
+		v, err := config.GetString(test.key)
+		if err != nil {
+			t.Errorf("Key %s not found: %s\n", test.key, err)
+		}
+		if v != test.expected {
+			t.Errorf("Expected %q got %q\n", test.expected, v)
+		}
+	}
+}
+
+func Test_UpdateValues(t *testing.T) {
+	config := newConfigFromFile("values", t)
+
+	golden := getGolden("values.cfg", t)
+
+	config.SetString("bare", "bar foo baz")
+	config.SetString("quoted", " padded ")
+	config.SetString("spaced", "foo bar baz")
+	config.SetString("inner", "foo \"bar\"")
+
+	if config.String() != golden {
+		t.Errorf("Expected %q got %q\n", golden, config.String())
+	}
+}
+
+func Test_ValuesRoundTrip(t *testing.T) {
+	values := []string{
+		"",
+		" ",
+		"foo bar",
+		"  leading and trailing  ",
+		"\"quoted\"",
+		"\"",
+		"back\\slash\\",
+		"new\nline\r\n",
+		"\ttab",
+		"control\x00\x1f\x7f",
+		"unicode åäö 😀",
+		"= # [section]",
+		`\u00e5 \n \"`,
+		"invalid a\xffb \xc3 \xe2\x82",
+		"replacement \uFFFD",
+		`\xff`,
+	}
+
+	config := cfg.NewConfig()
+	for i, v := range values {
+		config.SetString(fmt.Sprintf("key%d", i), v)
+	}
+
+	parsed, err := cfg.NewConfigFromReader(strings.NewReader(config.String()), cfg.Strict())
+	if err != nil {
+		t.Fatalf("Error parsing config: %s\n", err)
+	}
+	for i, v := range values {
+		s, err := parsed.GetString(fmt.Sprintf("key%d", i))
+		if err != nil {
+			t.Errorf("Key key%d not found: %s\n", i, err)
+		}
+		if s != v {
+			t.Errorf("Expected %q got %q\n", v, s)
+		}
+	}
+}
+
+func Test_StrictValues(t *testing.T) {
+	tests := []struct {
+		source string
+		column int
+		reason string
+	}{
+		{`foo = "bar`, 7, "unterminated quoted string"},
+		{`foo = "bar\"`, 7, "unterminated quoted string"},
+		{`foo = C:\dir`, 9, "invalid escape sequence"},
+		{`foo = \u12`, 7, "invalid escape sequence"},
+	}
+
+	for _, test := range tests {
+		_, err := cfg.NewConfigFromReader(strings.NewReader(test.source), cfg.Strict())
+		pe, ok := err.(*cfg.ParseError)
+		if !ok {
+			t.Errorf("Expected *ParseError for %q got %v\n", test.source, err)
+			continue
+		}
+		if pe.Column != test.column || pe.Reason != test.reason {
+			t.Errorf("Expected %d: %s got %d: %s\n", test.column, test.reason, pe.Column, pe.Reason)
+		}
+	}
+}
+
+func Test_Multiline(t *testing.T) {
+	config := newConfigFromFile("multiline", t)
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"query", "SELECT * FROM users WHERE id = 1"},
+		{"cert", "-----BEGIN CERTIFICATE-----\n  # not a comment \\n\n-----END CERTIFICATE-----"},
+		{"template.body", "\nHello!"},
+		{"template.after", "value"},
+	}
+
+	for _, test := range tests {
+		v, err := config.GetString(test.key)
+		if err != nil {
+			t.Errorf("Key %s not found: %s\n", test.key, err)
+		}
+		if v != test.expected {
+			t.Errorf("Expected %q got %q\n", test.expected, v)
+		}
+	}
+
+	comment, _ := config.KeyComment("cert")
+	if comment != "A certificate" {
+		t.Errorf("Expected %q got %q\n", "A certificate", comment)
+	}
+	if len(config.Comments()) != 2 {
+		t.Errorf("Expected 2 comments got %v\n", config.Comments())
+	}
+
+	b, err := ioutil.ReadFile("_testdata/multiline.cfg")
+	if err != nil {
+		t.Errorf("Error reading test data: %s\n", err)
+	}
+	if config.String() != strings.TrimRight(string(b), "\n") {
+		t.Errorf("Expected %q got %q\n", string(b), config.String())
+	}
+}
+
+func Test_MultilineUpdate(t *testing.T) {
+	config := newConfigFromFile("multiline", t)
+
+	golden := getGolden("multiline.cfg", t)
+
+	config.SetString("query", "SELECT 1")
+	config.SetString("cert", "new\ncertificate")
+	config.SetString("template.body", "single line")
+	config.SetMultilineString("template.message", "first\nEOF")
+
+	if config.String() != golden {
+		t.Errorf("Expected %q got %q\n", golden, config.String())
+	}
+
+	message, _ := config.GetString("template.message")
+	if message != "first\nEOF" {
+		t.Errorf("Expected %q got %q\n", "first\nEOF", message)
+	}
+}
+
+func Test_MultilineLineNumbers(t *testing.T) {
+	source := "foo = a \\\n  b\nbar = <<EOF\nx\nEOF\nfoo = c\nbaz"
+
+	config, err := cfg.NewConfigFromReader(strings.NewReader(source))
+	if err != nil {
+		t.Errorf("Error creating config: %s\n", err)
+	}
+	if fmt.Sprint(config.Duplicates()["foo"]) != "[1 6]" {
+		t.Errorf("Expected %v got %v\n", "[1 6]", config.Duplicates()["foo"])
+	}
+
+	_, err = cfg.NewConfigFromReader(strings.NewReader(source), cfg.Strict())
+	pe, ok := err.(*cfg.ParseError)
+	if !ok || pe.Line != 7 {
+		t.Errorf("Expected *ParseError on line 7 got %v\n", err)
+	}
+
+	_, err = cfg.NewConfigFromReader(strings.NewReader("foo = bar\ncert = <<EOF\nx\n"))
+	pe, ok = err.(*cfg.ParseError)
+	if !ok {
+		t.Fatalf("Expected *ParseError got %v\n", err)
+	}
+	if pe.Line != 2 || pe.Column != 8 || pe.Reason != "unterminated heredoc, missing EOF" {
+		t.Errorf("Unexpected error %s\n", pe)
+	}
+}
+
+func Test_Lists(t *testing.T) {
+	config := newConfigFromFile("lists", t)
+
+	hosts, err := config.GetStrings("hosts")
+	if err != nil {
+		t.Errorf("Key hosts not found: %s\n", err)
+	}
+	if fmt.Sprintf("%q", hosts) != `["alpha" "beta, gamma" "delta"]` {
+		t.Errorf("Unexpected hosts %q\n", hosts)
+	}
+
+	ports, err := config.GetInts("ports")
+	if err != nil || fmt.Sprint(ports) != "[80 443]" {
+		t.Errorf("Expected %v got %v, %v\n", "[80 443]", ports, err)
+	}
+
+	ratios, err := config.GetFloats("ratios")
+	if err != nil || fmt.Sprint(ratios) != "[0.5 1]" {
+		t.Errorf("Expected %v got %v, %v\n", "[0.5 1]", ratios, err)
+	}
+
+	flags, err := config.GetBools("flags")
+	if err != nil || fmt.Sprint(flags) != "[true false]" {
+		t.Errorf("Expected %v got %v, %v\n", "[true false]", flags, err)
+	}
+
+	empty, err := config.GetStrings("empty")
+	if err != nil || len(empty) != 0 {
+		t.Errorf("Expected empty list got %q, %v\n", empty, err)
+	}
+
+	single, err := config.GetStrings("single")
+	if err != nil || fmt.Sprintf("%q", single) != `["  padded  "]` {
+		t.Errorf("Unexpected single %q, %v\n", single, err)
+	}
+
+	_, err = config.GetInts("hosts")
+	var ve *cfg.ValueError
+	if !errors.As(err, &ve) || ve.Value != "alpha" {
+		t.Errorf("Expected *ValueError for alpha got %v\n", err)
+	}
+
+	_, err = config.GetStrings("undefined")
+	if !errors.Is(err, cfg.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound got %v\n", err)
+	}
+}
+
+func Test_ListsUpdate(t *testing.T) {
+	config := newConfigFromFile("lists", t)
+
+	golden := getGolden("lists.cfg", t)
+
+	config.SetStrings("hosts", []string{"a, b", "c"})
+	config.SetInts("ports", []int{1, 2, 3})
+	config.SetFloats("ratios", []float64{0.5, 1})
+	config.SetBools("flags", []bool{true, false})
+	config.SetStrings("empty", nil)
+	config.SetStrings("names", []string{"x", " y", `"z"`})
+
+	if config.String() != golden {
+		t.Errorf("Expected %q got %q\n", golden, config.String())
+	}
+
+	names, _ := config.GetStrings("names")
+	if fmt.Sprintf("%q", names) != `["x" " y" "\"z\""]` {
+		t.Errorf("Unexpected names %q\n", names)
+	}
+}
+
+func Test_ListsCollected(t *testing.T) {
+	source := "host = a, b\nhost = c"
+	r := strings.NewReader(source)
+	config, err := cfg.NewConfigFromReader(r, cfg.WithDuplicatePolicy(cfg.CollectList))
+	if err != nil {
+		t.Errorf("Error creating config: %s\n", err)
+	}
+
+	hosts, _ := config.GetStrings("host")
+	if fmt.Sprint(hosts) != "[a b c]" {
+		t.Errorf("Expected %v got %v\n", "[a b c]", hosts)
+	}
+}
+
+func Test_Maps(t *testing.T) {
+	config := newConfigFromFile("maps", t)
+
+	limits, err := config.GetIntMap("limit")
+	if err != nil {
+		t.Errorf("Prefix limit not found: %s\n", err)
+	}
+	if fmt.Sprint(limits) != "map[acme:10 globex:20 initech:30]" {
+		t.Errorf("Unexpected limits %v\n", limits)
+	}
+
+	labels, err := config.GetMap("labels")
+	if err != nil {
+		t.Errorf("Prefix labels not found: %s\n", err)
+	}
+	if fmt.Sprint(labels) != "map[env:production team:core, platform]" {
+		t.Errorf("Unexpected labels %v\n", labels)
+	}
+
+	_, err = config.GetIntMap("labels")
+	var ve *cfg.ValueError
+	if !errors.As(err, &ve) {
+		t.Errorf("Expected *ValueError got %v\n", err)
+	}
+
+	_, err = config.GetMap("undefined")
+	if !errors.Is(err, cfg.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound got %v\n", err)
+	}
+}
+
+func Test_Times(t *testing.T) {
+	src := "timeout = 1m30s\nexpires = 2030-01-02T03:04:05Z\nbad = 5 seconds"
+	config, err := cfg.NewConfigFromReader(strings.NewReader(src))
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+
+	timeout, err := config.GetDuration("timeout")
+	if err != nil || timeout != 90*time.Second {
+		t.Errorf("Expected %v got %v, %v\n", 90*time.Second, timeout, err)
+	}
+
+	expires, err := config.GetTime("expires")
+	expected := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err != nil || !expires.Equal(expected) {
+		t.Errorf("Expected %v got %v, %v\n", expected, expires, err)
+	}
+
+	var ve *cfg.ValueError
+	_, err = config.GetDuration("bad")
+	if !errors.As(err, &ve) || ve.Type != "duration" {
+		t.Errorf("Expected *ValueError for duration got %v\n", err)
+	}
+	_, err = config.GetTime("bad")
+	if !errors.As(err, &ve) || ve.Type != "time" {
+		t.Errorf("Expected *ValueError for time got %v\n", err)
+	}
+
+	config.SetDuration("timeout", 250*time.Millisecond)
+	config.SetTime("expires", time.Date(2031, 6, 7, 8, 9, 10, 500000000, time.FixedZone("", 2*60*60)))
+	expectedString := "timeout = 250ms\nexpires = 2031-06-07T08:09:10.5+02:00\nbad = 5 seconds"
+	if config.String() != expectedString {
+		t.Errorf("Expected %q got %q\n", expectedString, config.String())
+	}
+}
+
+func Test_EnvOverrides(t *testing.T) {
+	os.Setenv("CFGTEST_DB_HOST", "db.example.com")
+	os.Setenv("CFGTEST_PORTS", "80, 443")
+	os.Setenv("CFGTEST_LIMIT_ACME", "20")
+	os.Setenv("CFGTEST_NAME", "from env")
+	os.Setenv("CFGTEST_MOTD", "line1\nline2")
+	defer os.Unsetenv("CFGTEST_MOTD")
+	defer os.Unsetenv("CFGTEST_DB_HOST")
+	defer os.Unsetenv("CFGTEST_PORTS")
+	defer os.Unsetenv("CFGTEST_LIMIT_ACME")
+	defer os.Unsetenv("CFGTEST_NAME")
+
+	src := "[db]\nhost = localhost\nport = 5432\n\n[limit]\nacme = 10\nglobex = 30\n\n[]\nports = 8080\nmotd = hello"
+	config, err := cfg.NewConfigFromReader(strings.NewReader(src), cfg.WithEnvPrefix("CFGTEST_"))
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+
+	host, err := config.GetString("db.host")
+	if err != nil || host != "db.example.com" {
+		t.Errorf("Expected db.example.com got %s, %v\n", host, err)
+	}
+	port, err := config.GetInt("db.port")
+	if err != nil || port != 5432 {
+		t.Errorf("Expected 5432 got %d, %v\n", port, err)
+	}
+	ports, err := config.GetInts("ports")
+	if err != nil || fmt.Sprint(ports) != "[80 443]" {
+		t.Errorf("Expected [80 443] got %v, %v\n", ports, err)
+	}
+	limits, err := config.GetIntMap("limit")
+	if err != nil || fmt.Sprint(limits) != "map[acme:20 globex:30]" {
+		t.Errorf("Expected map[acme:20 globex:30] got %v, %v\n", limits, err)
+	}
+	name, err := config.GetString("name")
+	if err != nil || name != "from env" {
+		t.Errorf("Expected from env got %s, %v\n", name, err)
+	}
+	motd, err := config.GetString("motd")
+	if err != nil || motd != "line1\nline2" {
+		t.Errorf("Expected %q got %q, %v\n", "line1\nline2", motd, err)
+	}
+
+	if config.String() != src {
+		t.Errorf("Expected overrides to not be written, got %q\n", config.String())
+	}
+
+	keys := config.ApplyEnvOverrides()
+	if fmt.Sprint(keys) != "[db.host limit.acme ports motd]" {
+		t.Errorf("Expected applied keys [db.host limit.acme ports motd] got %v\n", keys)
+	}
+	expected := "[db]\nhost = db.example.com\nport = 5432\n\n[limit]\nacme = 20\nglobex = 30\n\n[]\nports = 80, 443\nmotd = line1\\nline2"
+	if config.String() != expected {
+		t.Errorf("Expected %q got %q\n", expected, config.String())
+	}
+}
+
+func Test_Interpolation(t *testing.T) {
+	os.Setenv("CFGTEST_HOME", "/home/app")
+	defer os.Unsetenv("CFGTEST_HOME")
+
+	src := `base = ${env:CFGTEST_HOME}/data
+logs = "${base}/logs"
+price = \${base} costs $5
+ports = ${port}, 443
+port = 80
+
+[db]
+path = ${base}/db
+url = file://${db.path}?mode=${mode}`
+	config, err := cfg.NewConfigFromReader(strings.NewReader(src))
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"base", "/home/app/data"},
+		{"logs", "/home/app/data/logs"},
+		{"price", "${base} costs $5"},
+		{"db.path", "/home/app/data/db"},
+	}
+	for _, test := range tests {
+		val, err := config.GetString(test.key)
+		if err != nil || val != test.expected {
+			t.Errorf("Expected %q for %s got %q, %v\n", test.expected, test.key, val, err)
+		}
+	}
+
+	ports, err := config.GetInts("ports")
+	if err != nil || fmt.Sprint(ports) != "[80 443]" {
+		t.Errorf("Expected [80 443] got %v, %v\n", ports, err)
+	}
+
+	raw, err := config.GetRawString("logs")
+	if err != nil || raw != "${base}/logs" {
+		t.Errorf("Expected ${base}/logs got %q, %v\n", raw, err)
+	}
+
+	_, err = config.GetString("db.url")
+	var refErr *cfg.ReferenceError
+	if !errors.As(err, &refErr) || refErr.Key != "db.url" || refErr.Ref != "mode" {
+		t.Errorf("Expected *cfg.ReferenceError for mode got %v\n", err)
+	}
+
+	config.SetString("mode", "${db.url}")
+	mode, err := config.GetString("mode")
+	if err != nil || mode != "${db.url}" {
+		t.Errorf("Expected literal ${db.url} got %q, %v\n", mode, err)
+	}
+
+	config.SetString("mode", "ro")
+	url, err := config.GetString("db.url")
+	if err != nil || url != "file:///home/app/data/db?mode=ro" {
+		t.Errorf("Expected file:///home/app/data/db?mode=ro got %q, %v\n", url, err)
+	}
+	if !strings.Contains(config.String(), "url = file://${db.path}?mode=${mode}") {
+		t.Errorf("Expected references to be kept got %q\n", config.String())
+	}
+
+	config, err = cfg.NewConfigFromReader(strings.NewReader("a = ${b}\nb = x${c}\nc = ${a}"))
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+	_, err = config.GetString("a")
+	expected := "cfg: can not resolve ${a} in c: reference cycle a -> b -> c -> a"
+	if !errors.As(err, &refErr) || err.Error() != expected {
+		t.Errorf("Expected %q got %v\n", expected, err)
+	}
+}
+
 func newConfigFromFile(filename string, t *testing.T) *cfg.Config {
 	f, err := os.Open(fmt.Sprintf("_testdata/%s.cfg", filename))
 	if err != nil {
@@ -311,3 +1224,84 @@ func Test_ParseError(t *testing.T) {
 		t.Errorf("Expected parse error but got none")
 	}
 }
+
+func Test_Stack(t *testing.T) {
+	defaults, _ := cfg.NewConfigFromReader(strings.NewReader("host = localhost\nport = 80\n[db]\nname = app\nurl = ${host}/${db.name}"))
+	user, _ := cfg.NewConfigFromReader(strings.NewReader("# User config\n\nhost = example.com\n[db]\nname = users"))
+	os.Setenv("CFGSTACK_PORT", "8080")
+	os.Setenv("CFGSTACK_DEBUG", "true")
+	defer os.Unsetenv("CFGSTACK_PORT")
+	defer os.Unsetenv("CFGSTACK_DEBUG")
+	env := cfg.NewConfig(cfg.WithEnvPrefix("CFGSTACK_"))
+
+	stack := cfg.NewStack(
+		cfg.Layer{Name: "env", Config: env},
+		cfg.Layer{Name: "user", Config: user},
+		cfg.Layer{Name: "defaults", Config: defaults},
+	)
+
+	if v, _ := stack.GetString("host"); v != "example.com" {
+		t.Errorf("Expected %q got %q\n", "example.com", v)
+	}
+	if v, _ := stack.GetInt("port"); v != 8080 {
+		t.Errorf("Expected %v got %v\n", 8080, v)
+	}
+	if v, err := stack.GetBool("debug"); err != nil || !v {
+		t.Errorf("Expected %v got %v, %v\n", true, v, err)
+	}
+	if v, _ := stack.GetString("db.url"); v != "example.com/users" {
+		t.Errorf("Expected %q got %q\n", "example.com/users", v)
+	}
+	if _, err := stack.GetString("missing"); !errors.Is(err, cfg.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound got %v\n", err)
+	}
+
+	origins := []struct {
+		key   string
+		layer string
+		line  int
+	}{
+		{"port", "env", 0},
+		{"debug", "env", 0},
+		{"host", "user", 3},
+		{"db.name", "user", 5},
+		{"db.url", "defaults", 5},
+	}
+	for _, o := range origins {
+		layer, line, err := stack.Origin(o.key)
+		if err != nil {
+			t.Errorf("Error getting origin of %s: %s\n", o.key, err)
+		}
+		if layer != o.layer || line != o.line {
+			t.Errorf("Expected %s line %d for %s got %s line %d\n", o.layer, o.line, o.key, layer, line)
+		}
+	}
+
+	stack.Layer("defaults").SetString("timeout", "5s")
+	stack.Layer("user").Unset("host")
+	if v, _ := stack.GetDuration("timeout"); v != 5*time.Second {
+		t.Errorf("Expected %v got %v\n", 5*time.Second, v)
+	}
+	if layer, _, _ := stack.Origin("host"); layer != "defaults" {
+		t.Errorf("Expected %q got %q\n", "defaults", layer)
+	}
+	if stack.Layer("flags") != nil {
+		t.Errorf("Expected no layer named flags\n")
+	}
+
+	var s struct {
+		Host  string
+		Port  int
+		Debug bool
+		DB    struct {
+			Name string
+		}
+	}
+	err := cfg.UnmarshalFromConfig(stack, &s)
+	if err != nil {
+		t.Errorf("Error unmarshalling stack: %s\n", err)
+	}
+	if s.Host != "localhost" || s.Port != 8080 || !s.Debug || s.DB.Name != "users" {
+		t.Errorf("Expected localhost, 8080, true, users got %v\n", s)
+	}
+}
@@ -2,25 +2,71 @@ package cfg
 
 import (
 	"bytes"
+	"encoding"
 	"errors"
 	"fmt"
+	"os"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // tagKey is used as the key for struct field tags
 const tagKey = "cfg"
 
+// defaultKey is used as the key for struct field tags with default values
+const defaultKey = "default"
+
+// envKey is used as the key for struct field tags with the names of
+// environment variables overriding the values
+const envKey = "env"
+
 // Unmarshal parses the config data and stores the result in the
 // value pointed to by v. v must be a pointer to a struct.
 //
 // Unmarshal matches incoming keys to either the struct field name or its
-// tag, preferring an exact match but also accepting a case-insensitive match.
+// tag. A key equal to the tag is preferred, then a key equal to the field
+// name, then a key equal to either case insensitive. If several keys match
+// a field equally well an *AmbiguousKeyError is returned.
 // Only exported fields can be populated. The tag value "-" is used to skip
 // a field.
 //
+// Fields without a matching key are set to the value of their "default" tag,
+// written as in a config, eg. `cfg:"port" default:"8080"`. Fields with the
+// tag option "required", eg. `cfg:"port,required"`, and without a default
+// must have a matching key, otherwise a *RequiredError listing all missing
+// keys is returned. Other fields without a matching key are left as they are.
+//
+// The values can be validated by constraints in the struct field's tags.
+// Numbers, durations and times are compared with the "min" and "max" tags,
+// and strings, slices and maps by their length, eg. `min:"1" max:"65535"`.
+// The text of a value, or each element of a slice, must be one of the space
+// separated values in the "oneof" tag and match the regular expression in
+// the "pattern" tag. Fields with the tag option "nonempty", eg.
+// `cfg:"name,nonempty"`, must not be empty as defined for Marshal. A
+// *ValidationError listing all violations is returned after decoding.
+//
+// Environment variables override the values in the config, for the keys
+// matching a field and the keys of fields not in the config, if the config
+// is created with WithEnvPrefix. The "env" tag names the variable overriding
+// a field, eg. `cfg:"port" env:"PORT"`, with or without a prefix.
+//
+// Nested structs are populated from the keys with the key of the struct field
+// and the keys of its fields joined by a dot, eg. "db.host", or from a section
+// with the name of the field. Embedded structs are populated the same way,
+// unless the field's tag has the option "squash" or "inline".
+//
+// All integer, unsigned integer, float, bool and string kinds, time.Duration
+// and time.Time are supported, as well as slices and maps of them. Pointer
+// fields are only allocated if the key is present, so a nil pointer means the
+// key is not set.
+//
 // If the type indicated in the struct field does not match the type in the
-// config the field is skipped. Eg. the field type is int but contains a non
-// numerical string value in the config data.
+// config a *FieldError is returned. Eg. the field type is int but contains a
+// non numerical string value in the config data, or a value overflowing the
+// field type.
 func Unmarshal(data []byte, v interface{}) error {
 	// Parse the config
 	buf := bytes.NewBuffer(data)
@@ -33,89 +79,629 @@ func Unmarshal(data []byte, v interface{}) error {
 }
 
 // UnmarshalFromConfig strores the data in config in the value pointed to by v.
-// v must be a pointer to a struct.
+// v must be a pointer to a struct. The config can be a *Config, a *ConfigFile
+// or a *Stack.
 //
 // UnmarshalFromConfig matches incoming keys to either the struct field name
-// or its tag, preferring an exact match but also accepting
-// a case-insensitive match.
+// or its tag. A key equal to the tag is preferred, then a key equal to the
+// field name, then a key equal to either case insensitive. If several keys
+// match a field equally well an *AmbiguousKeyError is returned. Use a Decoder
+// with the option CaseSensitive to only match keys with the same case.
 // Only exported fields can be populated. The tag value "-" is used to skip
 // a field.
 //
+// Fields without a matching key are set to the value of their "default" tag,
+// written as in a config, eg. `cfg:"port" default:"8080"`. Fields with the
+// tag option "required", eg. `cfg:"port,required"`, and without a default
+// must have a matching key, otherwise a *RequiredError listing all missing
+// keys is returned. Other fields without a matching key are left as they are.
+//
+// The values can be validated by constraints in the struct field's tags.
+// Numbers, durations and times are compared with the "min" and "max" tags,
+// and strings, slices and maps by their length, eg. `min:"1" max:"65535"`.
+// The text of a value, or each element of a slice, must be one of the space
+// separated values in the "oneof" tag and match the regular expression in
+// the "pattern" tag. Fields with the tag option "nonempty", eg.
+// `cfg:"name,nonempty"`, must not be empty as defined for Marshal. A
+// *ValidationError listing all violations is returned after decoding.
+//
+// Environment variables override the values in the config, for the keys
+// matching a field and the keys of fields not in the config, if the config
+// is created with WithEnvPrefix. The "env" tag names the variable overriding
+// a field, eg. `cfg:"port" env:"PORT"`, with or without a prefix.
+//
+// Nested structs are populated from the keys with the key of the struct field
+// and the keys of its fields joined by a dot, eg. "db.host", or from a section
+// with the name of the field. Embedded structs are populated the same way,
+// unless the field's tag has the option "squash" or "inline".
+//
+// All integer, unsigned integer, float, bool and string kinds, time.Duration
+// and time.Time are supported, as well as slices and maps of them. Pointer
+// fields are only allocated if the key is present, so a nil pointer means the
+// key is not set.
+//
 // If the type indicated in the struct field does not match the type in the
-// config the field is skipped. Eg. the field type is int but contains a non
-// numerical string value in the config data.
-func UnmarshalFromConfig(c *Config, v interface{}) error {
+// config a *FieldError is returned. Eg. the field type is int but contains a
+// non numerical string value in the config data, or a value overflowing the
+// field type. The error wraps a *ValueError describing the value.
+//
+// Keys that do not match any field are ignored, use a Decoder with the
+// option DisallowUnknownKeys to report them.
+func UnmarshalFromConfig(c Source, v interface{}) error {
+	return NewDecoder(c).Decode(v)
+}
+
+// A Decoder decodes a config into structs.
+type Decoder struct {
+	c                   Source
+	disallowUnknownKeys bool
+	caseSensitive       bool
+	unused              []string
+}
+
+// NewDecoder returns a new decoder that decodes the config c, configured by
+// options. c can be a *Config, a *ConfigFile or a *Stack.
+func NewDecoder(c Source, options ...DecoderOption) *Decoder {
+	dec := &Decoder{c: c}
+	for _, option := range options {
+		option(dec)
+	}
+
+	return dec
+}
+
+// Decode stores the data in the config in the value pointed to by v, as
+// described for UnmarshalFromConfig.
+// If the decoder disallows unknown keys and the config has keys that do not
+// match any field an *UnknownKeyError is returned, after populating v.
+func (dec *Decoder) Decode(v interface{}) error {
 	// Check that the type v we will populate is a struct
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
 		return errors.New("cfg: interface must be a pointer to struct")
 	}
 
-	// Dereference the pointer if it is one
-	rv = rv.Elem()
+	// Work on a copy so the config can be changed by others while decoding
+	d := newDecodeState(dec.c.Snapshot(), dec.caseSensitive)
+	err := d.decodeStruct(rv.Elem(), "")
+	if err != nil {
+		return err
+	}
+
+	dec.unused = d.unusedKeys()
+	if dec.disallowUnknownKeys && len(dec.unused) > 0 {
+		lines := make(map[string]int, len(dec.unused))
+		for _, key := range dec.unused {
+			lines[key] = d.lines[key]
+		}
+		return &UnknownKeyError{Keys: dec.unused, Lines: lines}
+	}
+	if len(d.missing) > 0 {
+		return &RequiredError{Keys: d.missing}
+	}
+	if len(d.violations) > 0 {
+		return &ValidationError{Violations: d.violations}
+	}
+
+	return nil
+}
+
+// UnusedKeys returns the keys in the config that did not match any struct
+// field in the last call to Decode, in the order they are defined.
+func (dec *Decoder) UnusedKeys() []string {
+	return dec.unused
+}
+
+// decodeState holds the state of decoding a config into a struct.
+type decodeState struct {
+	c             *Config
+	caseSensitive bool
+	keys          []string        // All keys in the config
+	prefixes      []string        // All prefixes of keys in the config
+	missing       []string        // Keys of required fields not found in the config
+	violations    []Violation     // Values violating the constraints of their fields
+	used          map[string]bool // Keys in the config matching a field
+	indexes       map[string]int  // Index in the raw data of the line defining each key
+	lines         map[string]int  // Line number of the line defining each key
+}
+
+// newDecodeState returns the state for decoding the config c.
+func newDecodeState(c *Config, caseSensitive bool) *decodeState {
+	d := &decodeState{
+		c:             c,
+		caseSensitive: caseSensitive,
+		keys:          make([]string, 0, len(c.values)),
+		prefixes:      make([]string, 0),
+		used:          make(map[string]bool),
+		indexes:       make(map[string]int, len(c.values)),
+		lines:         make(map[string]int, len(c.values)),
+	}
+
+	// Find the line defining each key in one pass, as Config.index and
+	// Config.lineNumber each scan the raw data
+	next := make(map[string]int) // Next line number by source file
+	c.scan(func(i int, section string) bool {
+		source := c.sourceAt(i)
+		n := next[source] + 1
+		next[source] = n + strings.Count(c.raw[i], "\n")
+		k, _, ok := splitKeyValue(c.raw[i])
+		if !ok {
+			return true
+		}
+		key := qualify(section, k)
+		if _, defined := d.indexes[key]; !defined || c.duplicates != FirstWins {
+			d.indexes[key] = i
+			d.lines[key] = n
+		}
+		return true
+	})
+
+	seen := make(map[string]bool)
+	for key := range c.values {
+		d.keys = append(d.keys, key)
+		for i := strings.Index(key, "."); i != -1; i = nextIndex(key, ".", i) {
+			if !seen[key[:i]] {
+				seen[key[:i]] = true
+				d.prefixes = append(d.prefixes, key[:i])
+			}
+		}
+	}
+
+	return d
+}
+
+// use marks key, and all keys under it as a prefix, as matching a field.
+func (d *decodeState) use(key string) {
+	for _, k := range d.keys {
+		if k == key || strings.HasPrefix(k, key+".") {
+			d.used[k] = true
+		}
+	}
+}
+
+// unusedKeys returns the keys in the config not matching any field, in the
+// order they are defined.
+func (d *decodeState) unusedKeys() []string {
+	keys := make([]string, 0)
+	for _, key := range d.keys {
+		if !d.used[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return d.indexes[keys[i]] < d.indexes[keys[j]]
+	})
 
+	return keys
+}
+
+// match returns the one of candidates that best matches the struct field sf
+// with the tag name tag, under prefix. A candidate equal to the tag is the
+// best match, then a candidate equal to the field name, then a candidate
+// equal to the tag or the field name case insensitive, unless matching is
+// case sensitive.
+// Returns an empty string if no candidate matches, and an
+// *AmbiguousKeyError if several candidates are the best match.
+func (d *decodeState) match(candidates []string, sf reflect.StructField, prefix, tag string) (string, error) {
+	exact := []string{prefix + sf.Name}
+	if tag != "" {
+		exact = []string{prefix + tag, prefix + sf.Name}
+	}
+
+	// Check the exact matches in order, then the case insensitive ones
+	for _, name := range exact {
+		for _, candidate := range candidates {
+			if candidate == name {
+				return candidate, nil
+			}
+		}
+	}
+	if d.caseSensitive {
+		return "", nil
+	}
+	for _, name := range exact {
+		matches := make([]string, 0)
+		for _, candidate := range candidates {
+			if strings.EqualFold(candidate, name) && !containsString(matches, candidate) {
+				matches = append(matches, candidate)
+			}
+		}
+		if len(matches) > 1 {
+			sort.Strings(matches)
+			return "", &AmbiguousKeyError{Field: sf.Name, Keys: matches}
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+	}
+
+	return "", nil
+}
+
+// decodeStruct populates the fields of the struct rv from the keys in config
+// prefixed by prefix.
+func (d *decodeState) decodeStruct(rv reflect.Value, prefix string) error {
 	// Loop through all fields of the struct
 	for i := 0; i < rv.NumField(); i++ {
 		fv := rv.Field(i)        // Save the Value of the field
 		sf := rv.Type().Field(i) // Save the StructField of the field
 
 		// Check if the field should be skipped
-		if sf.PkgPath != "" { // unexported
+		if sf.PkgPath != "" && !(sf.Anonymous && fv.Kind() == reflect.Struct) { // unexported
 			continue
 		}
-		tag := sf.Tag.Get(tagKey)
-		if tag == "-" {
+		tag, opts := parseTag(sf.Tag.Get(tagKey))
+		if tag == "-" && opts == "" {
 			continue
 		}
 
-		// Loop through all keys and match them against the field
-		// set the value if it matches.
-		for key := range c.values {
-			// Check so the tag, or the name case insensitive matches, if not
-			// go on to the next key
-			if key != tag && bytes.EqualFold([]byte(key), []byte(sf.Name)) == false {
-				continue
+		// Embedded structs with the squash option share the prefix
+		if isStruct(fv.Type()) && sf.Anonymous && opts.squash() {
+			err := d.decodeStruct(fv, prefix)
+			if err != nil {
+				return err
 			}
+			continue
+		}
 
-			err := setValue(&fv, c, key)
+		key, err := d.decodeField(&fv, sf, prefix, tag)
+		if err != nil {
+			return err
+		}
+
+		// Environment variables override the value in the config
+		envKey := key
+		if envKey == "" {
+			envKey = fieldKey(sf, prefix, tag)
+		}
+		if val, ok := d.lookupEnv(sf, envKey); ok {
+			key = envKey
+			err := setText(&fv, key, val)
 			if err != nil {
-				return fmt.Errorf("cfg: error setting field value: %s", err)
+				return &FieldError{Field: sf.Name, Key: key, Err: err}
+			}
+		}
+
+		// Use the default value, or report the field as missing if required
+		if key == "" {
+			key = fieldKey(sf, prefix, tag)
+			if def, ok := sf.Tag.Lookup(defaultKey); ok {
+				err := setText(&fv, key, def)
+				if err != nil {
+					return &FieldError{Field: sf.Name, Key: key, Err: err}
+				}
+			} else if opts.Contains("required") {
+				d.missing = append(d.missing, key)
 			}
 		}
+
+		err = d.validate(fv, sf, key, opts)
+		if err != nil {
+			return &FieldError{Field: sf.Name, Key: key, Err: err}
+		}
 	}
 
 	return nil
 }
 
+// decodeField populates the field value in fv from the key in config best
+// matching the struct field sf with the tag name tag, under prefix.
+// Returns the matching key, or an empty string if no key matches the field.
+func (d *decodeState) decodeField(fv *reflect.Value, sf reflect.StructField, prefix, tag string) (string, error) {
+	// The methods of unexported embedded structs can not be called, so those
+	// implementing Unmarshaler or encoding.TextUnmarshaler are skipped
+	if !fv.CanInterface() && (implements(fv.Type(), unmarshalerType) || implements(fv.Type(), textUnmarshalerType)) {
+		return "", nil
+	}
+
+	// Fields implementing Unmarshaler are given the key matching the field,
+	// either a key with a value or a prefix of keys
+	if implements(fv.Type(), unmarshalerType) {
+		candidates := append(append([]string{}, d.keys...), d.prefixes...)
+		key, err := d.match(candidates, sf, prefix, tag)
+		if err != nil || key == "" {
+			return "", err
+		}
+		d.use(key)
+		err = unmarshalValue(fv, d.c, key)
+		if err != nil {
+			return "", &FieldError{Field: sf.Name, Key: key, Err: err}
+		}
+		return key, nil
+	}
+
+	// Nested structs are populated from the keys under the prefix matching
+	// the field, pointers to structs are only allocated if there are any
+	// such keys
+	if isStruct(fv.Type()) || fv.Kind() == reflect.Ptr && isStruct(fv.Type().Elem()) {
+		key, err := d.match(d.prefixes, sf, prefix, tag)
+		if err != nil || key == "" && fv.Kind() == reflect.Ptr {
+			return "", err
+		}
+		if fv.Kind() == reflect.Ptr && fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		// Structs without any keys are still decoded to get their defaults
+		// and required fields
+		if key == "" {
+			return "", d.decodeStruct(reflect.Indirect(*fv), fieldKey(sf, prefix, tag)+".")
+		}
+		return key, d.decodeStruct(reflect.Indirect(*fv), key+".")
+	}
+
+	// Maps are populated from the keys under the prefix matching the field
+	if fv.Kind() == reflect.Map {
+		key, err := d.match(d.prefixes, sf, prefix, tag)
+		if err != nil || key == "" {
+			return "", err
+		}
+		d.use(key)
+		err = setMap(fv, d.c, key)
+		if err != nil {
+			return "", &FieldError{Field: sf.Name, Key: key, Err: err}
+		}
+		return key, nil
+	}
+
+	key, err := d.match(d.keys, sf, prefix, tag)
+	if err != nil || key == "" {
+		return "", err
+	}
+	d.used[key] = true
+	err = setValue(fv, d.c, key)
+	if err != nil {
+		return "", &FieldError{Field: sf.Name, Key: key, Err: err}
+	}
+
+	return key, nil
+}
+
+// fieldKey returns the key of the struct field sf with the tag name tag,
+// under prefix.
+func fieldKey(sf reflect.StructField, prefix, tag string) string {
+	if tag != "" {
+		return prefix + tag
+	}
+
+	return prefix + sf.Name
+}
+
+// lookupEnv returns the value of the environment variable overriding the
+// struct field sf with the key key, if it is set. The variable is named by
+// the "env" tag of the field, or by the environment prefix of the config.
+func (d *decodeState) lookupEnv(sf reflect.StructField, key string) (string, bool) {
+	if name := sf.Tag.Get(envKey); name != "" {
+		val, ok := os.LookupEnv(name)
+		return strings.TrimSpace(val), ok
+	}
+
+	return d.c.lookupEnv(key)
+}
+
+// setText updates the field value in fv to the value text, written as the
+// value of key would be written in a config. Line breaks in text are part of
+// the value.
+func setText(fv *reflect.Value, key, text string) error {
+	c := NewConfig()
+	c.set(key, singleLine(text))
+
+	return setValue(fv, c, key)
+}
+
 // setValue updates the field value in fv to the data extracted from config
-// with key.
+// with key. Pointers are allocated before the value they point to is set.
 func setValue(fv *reflect.Value, c *Config, key string) error {
-	// Update the value with the correct type
-	switch fv.Kind() {
-	case reflect.Int:
-		val, err := c.GetInt(key)
+	switch {
+	case fv.Kind() == reflect.Ptr:
+		pv := reflect.New(fv.Type().Elem())
+		ev := pv.Elem()
+		err := setValue(&ev, c, key)
 		if err != nil {
 			return err
 		}
-		fv.SetInt(int64(val))
-	case reflect.Float64:
-		val, err := c.GetFloat(key)
+		fv.Set(pv)
+	case isDecodable(fv.Type()):
+		val, err := c.GetString(key)
 		if err != nil {
 			return err
 		}
-		fv.SetFloat(val)
-	case reflect.Bool:
-		val, err := c.GetBool(key)
+		return setScalar(fv, key, val)
+	case fv.Kind() == reflect.Slice:
+		return setList(fv, c, key)
+	}
+
+	return nil
+}
+
+// setList updates the slice field value in fv to the list extracted from
+// config with key. Slices of the single value types supported by setValue
+// are supported.
+func setList(fv *reflect.Value, c *Config, key string) error {
+	if !isDecodable(fv.Type().Elem()) {
+		return nil
+	}
+	elems, err := c.GetStrings(key)
+	if err != nil {
+		return err
+	}
+
+	sv := reflect.MakeSlice(fv.Type(), len(elems), len(elems))
+	for i, elem := range elems {
+		ev := sv.Index(i)
+		err := setScalar(&ev, key, elem)
 		if err != nil {
 			return err
 		}
-		fv.SetBool(val)
+	}
+	fv.Set(sv)
+
+	return nil
+}
+
+// setScalar updates the field value in fv to the value s parsed as the kind
+// of fv, or by UnmarshalText if fv implements encoding.TextUnmarshaler.
+// If s can not be represented by the kind, including values overflowing it, a
+// *ValueError is returned.
+func setScalar(fv *reflect.Value, key, s string) error {
+	switch fv.Type() {
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return &ValueError{Key: key, Value: s, Type: "duration", Err: err}
+		}
+		fv.SetInt(int64(d))
+		return nil
+	case timeType:
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return &ValueError{Key: key, Value: s, Type: "time", Err: err}
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	if fv.Kind() == reflect.Ptr && fv.IsNil() && implements(fv.Type(), textUnmarshalerType) {
+		fv.Set(reflect.New(fv.Type().Elem()))
+	}
+	if u, ok := addrInterface(*fv).(encoding.TextUnmarshaler); ok {
+		err := u.UnmarshalText([]byte(s))
+		if err != nil {
+			return &ValueError{Key: key, Value: s, Type: fv.Type().String(), Err: err}
+		}
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return &ValueError{Key: key, Value: s, Type: "integer", Err: err}
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return &ValueError{Key: key, Value: s, Type: "integer", Err: err}
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return &ValueError{Key: key, Value: s, Type: "float", Err: err}
+		}
+		fv.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return &ValueError{Key: key, Value: s, Type: "boolean", Err: err}
+		}
+		fv.SetBool(b)
 	case reflect.String:
-		val, err := c.GetString(key)
+		fv.SetString(s)
+	}
+
+	return nil
+}
+
+// isStruct reports whether values of type t are structs with fields stored as
+// separate values.
+func isStruct(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t == timeType {
+		return false
+	}
+	for _, it := range []reflect.Type{marshalerType, unmarshalerType, textMarshalerType, textUnmarshalerType} {
+		if implements(t, it) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isDecodable reports whether values of type t are decoded from a single
+// value.
+func isDecodable(t reflect.Type) bool {
+	return isScalar(t) || implements(t, textUnmarshalerType)
+}
+
+// isScalar reports whether values of type t are stored as a single value.
+func isScalar(t reflect.Type) bool {
+	if t == timeType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool, reflect.String:
+		return true
+	}
+
+	return false
+}
+
+// unmarshalValue lets the value in fv, implementing Unmarshaler, unmarshal
+// itself from the key in config. Pointers are allocated if nil.
+func unmarshalValue(fv *reflect.Value, c *Config, key string) error {
+	if fv.Kind() == reflect.Ptr && fv.IsNil() {
+		fv.Set(reflect.New(fv.Type().Elem()))
+	}
+
+	u, ok := addrInterface(*fv).(Unmarshaler)
+	if !ok {
+		return fmt.Errorf("cfg: can not unmarshal into unexported %s", fv.Type())
+	}
+
+	return u.UnmarshalCfg(key, c)
+}
+
+// containsString reports whether s is in list.
+func containsString(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nextIndex returns the index of the next instance of sep in s after i,
+// or -1 if there is none.
+func nextIndex(s, sep string, i int) int {
+	j := strings.Index(s[i+1:], sep)
+	if j == -1 {
+		return -1
+	}
+
+	return i + 1 + j
+}
+
+// setMap adds the values of all keys under prefix in config to the map
+// field value in fv. Maps with string keys and values of the types supported
+// by setValue are supported.
+func setMap(fv *reflect.Value, c *Config, prefix string) error {
+	if fv.Type().Key().Kind() != reflect.String {
+		return nil
+	}
+	et := fv.Type().Elem()
+	if !isDecodable(et) && !(et.Kind() == reflect.Ptr && isDecodable(et.Elem())) {
+		return nil
+	}
+
+	raws, err := c.getMap(prefix)
+	if err != nil {
+		return err
+	}
+	if fv.IsNil() {
+		fv.Set(reflect.MakeMap(fv.Type()))
+	}
+	for k := range raws {
+		ev := reflect.New(et).Elem()
+		err := setValue(&ev, c, prefix+"."+k)
 		if err != nil {
 			return err
 		}
-		fv.SetString(val)
+		fv.SetMapIndex(reflect.ValueOf(k).Convert(fv.Type().Key()), ev)
 	}
 
 	return nil
@@ -2,7 +2,14 @@ package cfg_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/walle/cfg"
 )
@@ -132,8 +139,695 @@ func Test_UnmarshalQuotedString(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error parsing the config: %s\n", err)
 	}
-	q := `"a quoted string"`
+	q := "a quoted string"
 	if myConfig.Quotes != q {
 		t.Errorf("Expected %q, got %q\n", q, myConfig.Quotes)
 	}
 }
+
+func Test_UnmarshalFieldError(t *testing.T) {
+	myConfig := &MyConfig{}
+	err := cfg.Unmarshal([]byte("answer = forty-two"), myConfig)
+
+	var fe *cfg.FieldError
+	if !errors.As(err, &fe) {
+		t.Fatalf("Expected *FieldError got %v\n", err)
+	}
+	if fe.Field != "Answer" || fe.Key != "answer" {
+		t.Errorf("Expected field Answer and key answer got %s and %s\n", fe.Field, fe.Key)
+	}
+
+	var ve *cfg.ValueError
+	if !errors.As(err, &ve) {
+		t.Fatalf("Expected *ValueError got %v\n", err)
+	}
+	if ve.Value != "forty-two" {
+		t.Errorf("Expected %q got %q\n", "forty-two", ve.Value)
+	}
+}
+
+func Test_UnmarshalLists(t *testing.T) {
+	type ListConfig struct {
+		Hosts  []string
+		Ports  []int
+		Ratios []float64
+		Flags  []bool
+	}
+
+	listConfig := &ListConfig{}
+	conf := "hosts = a, \"b, c\"\nports = 80, 443\nratios = 0.5\nflags = true"
+	err := cfg.Unmarshal([]byte(conf), listConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+
+	if len(listConfig.Hosts) != 2 || listConfig.Hosts[1] != "b, c" {
+		t.Errorf("Unexpected hosts %q\n", listConfig.Hosts)
+	}
+	if len(listConfig.Ports) != 2 || listConfig.Ports[1] != 443 {
+		t.Errorf("Unexpected ports %v\n", listConfig.Ports)
+	}
+	if len(listConfig.Ratios) != 1 || listConfig.Ratios[0] != 0.5 {
+		t.Errorf("Unexpected ratios %v\n", listConfig.Ratios)
+	}
+	if len(listConfig.Flags) != 1 || listConfig.Flags[0] != true {
+		t.Errorf("Unexpected flags %v\n", listConfig.Flags)
+	}
+
+	err = cfg.Unmarshal([]byte("ports = 80, http"), listConfig)
+	if err == nil {
+		t.Errorf("Expected error for invalid list element but got none\n")
+	}
+}
+
+func Test_UnmarshalMaps(t *testing.T) {
+	type MapConfig struct {
+		Limit  map[string]int
+		Labels map[string]string `cfg:"labels"`
+	}
+
+	mapConfig := &MapConfig{}
+	conf := "limit.acme = 10\nlimit.globex = 20\n[labels]\nenv = production"
+	err := cfg.Unmarshal([]byte(conf), mapConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+
+	if len(mapConfig.Limit) != 2 || mapConfig.Limit["acme"] != 10 || mapConfig.Limit["globex"] != 20 {
+		t.Errorf("Unexpected limits %v\n", mapConfig.Limit)
+	}
+	if len(mapConfig.Labels) != 1 || mapConfig.Labels["env"] != "production" {
+		t.Errorf("Unexpected labels %v\n", mapConfig.Labels)
+	}
+
+	err = cfg.Unmarshal([]byte("limit.acme = many"), mapConfig)
+	if err == nil {
+		t.Errorf("Expected error for invalid map value but got none\n")
+	}
+}
+
+type DBConfig struct {
+	Host string `cfg:"host"`
+	Port int    `cfg:"port"`
+}
+
+type Common struct {
+	Name string `cfg:"name"`
+}
+
+func Test_UnmarshalNested(t *testing.T) {
+	type NestedConfig struct {
+		Common `cfg:",squash"`
+		DB     DBConfig `cfg:"db"`
+		Cache  DBConfig
+	}
+
+	nestedConfig := &NestedConfig{}
+	conf := "name = app\ncache.host = localhost\n[db]\nhost = db.example.com\nport = 5432"
+	err := cfg.Unmarshal([]byte(conf), nestedConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+
+	if nestedConfig.Name != "app" {
+		t.Errorf("Expected name app got %s\n", nestedConfig.Name)
+	}
+	if nestedConfig.DB.Host != "db.example.com" || nestedConfig.DB.Port != 5432 {
+		t.Errorf("Unexpected db %v\n", nestedConfig.DB)
+	}
+	if nestedConfig.Cache.Host != "localhost" || nestedConfig.Cache.Port != 0 {
+		t.Errorf("Unexpected cache %v\n", nestedConfig.Cache)
+	}
+
+	err = cfg.Unmarshal([]byte("db.port = many"), nestedConfig)
+	if err == nil {
+		t.Errorf("Expected error for invalid nested value but got none\n")
+	}
+}
+
+func Test_UnmarshalEmbedded(t *testing.T) {
+	type EmbeddedConfig struct {
+		Common
+	}
+
+	embeddedConfig := &EmbeddedConfig{}
+	err := cfg.Unmarshal([]byte("name = ignored\ncommon.name = app"), embeddedConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+
+	if embeddedConfig.Name != "app" {
+		t.Errorf("Expected name app got %s\n", embeddedConfig.Name)
+	}
+}
+
+func Test_UnmarshalScalarKinds(t *testing.T) {
+	type ScalarConfig struct {
+		Int8    int8
+		Int64   int64
+		Uint16  uint16
+		Uint    uint
+		Float32 float32
+		Ratios  []float32
+		Ports   []uint16
+	}
+
+	scalarConfig := &ScalarConfig{}
+	conf := "int8 = -128\nint64 = 9223372036854775807\nuint16 = 65535\nuint = 7\nfloat32 = 0.5\nratios = 0.25, 0.75\nports = 80, 443"
+	err := cfg.Unmarshal([]byte(conf), scalarConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+
+	expected := ScalarConfig{
+		Int8:    -128,
+		Int64:   9223372036854775807,
+		Uint16:  65535,
+		Uint:    7,
+		Float32: 0.5,
+		Ratios:  []float32{0.25, 0.75},
+		Ports:   []uint16{80, 443},
+	}
+	if !reflect.DeepEqual(*scalarConfig, expected) {
+		t.Errorf("Expected %v got %v\n", expected, *scalarConfig)
+	}
+
+	for _, conf := range []string{"int8 = 128", "uint16 = 65536", "uint = -1", "float32 = 1e39", "ports = 80, 70000"} {
+		err = cfg.Unmarshal([]byte(conf), scalarConfig)
+		var valueErr *cfg.ValueError
+		if !errors.As(err, &valueErr) {
+			t.Errorf("Expected *cfg.ValueError for %q got %v\n", conf, err)
+		}
+	}
+}
+
+func Test_UnmarshalPointers(t *testing.T) {
+	type PointerConfig struct {
+		Port    *int
+		Debug   *bool
+		Name    *string
+		Timeout *float64
+		DB      *DBConfig `cfg:"db"`
+		Cache   *DBConfig
+	}
+
+	pointerConfig := &PointerConfig{}
+	err := cfg.Unmarshal([]byte("port = 0\ndebug = false\n[db]\nhost = localhost"), pointerConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+
+	if pointerConfig.Port == nil || *pointerConfig.Port != 0 {
+		t.Errorf("Expected port to be set to 0 got %v\n", pointerConfig.Port)
+	}
+	if pointerConfig.Debug == nil || *pointerConfig.Debug {
+		t.Errorf("Expected debug to be set to false got %v\n", pointerConfig.Debug)
+	}
+	if pointerConfig.Name != nil || pointerConfig.Timeout != nil {
+		t.Errorf("Expected name and timeout to be nil got %v and %v\n", pointerConfig.Name, pointerConfig.Timeout)
+	}
+	if pointerConfig.DB == nil || pointerConfig.DB.Host != "localhost" {
+		t.Errorf("Expected db to be set got %v\n", pointerConfig.DB)
+	}
+	if pointerConfig.Cache != nil {
+		t.Errorf("Expected cache to be nil got %v\n", pointerConfig.Cache)
+	}
+}
+
+func Test_UnmarshalTimes(t *testing.T) {
+	type TimeConfig struct {
+		Timeout  time.Duration
+		Retries  []time.Duration
+		Expires  time.Time
+		Deadline *time.Time
+	}
+
+	timeConfig := &TimeConfig{}
+	conf := "timeout = 5s\nretries = 1s, 2m\nexpires = 2030-01-02T03:04:05Z"
+	err := cfg.Unmarshal([]byte(conf), timeConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+
+	if timeConfig.Timeout != 5*time.Second {
+		t.Errorf("Expected timeout %v got %v\n", 5*time.Second, timeConfig.Timeout)
+	}
+	if fmt.Sprint(timeConfig.Retries) != "[1s 2m0s]" {
+		t.Errorf("Unexpected retries %v\n", timeConfig.Retries)
+	}
+	if !timeConfig.Expires.Equal(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Unexpected expires %v\n", timeConfig.Expires)
+	}
+	if timeConfig.Deadline != nil {
+		t.Errorf("Expected deadline to be nil got %v\n", timeConfig.Deadline)
+	}
+
+	err = cfg.Unmarshal([]byte("timeout = 5000"), timeConfig)
+	var valueErr *cfg.ValueError
+	if !errors.As(err, &valueErr) || valueErr.Type != "duration" {
+		t.Errorf("Expected *cfg.ValueError for duration got %v\n", err)
+	}
+}
+
+type Level int
+
+func (l Level) MarshalText() ([]byte, error) {
+	switch l {
+	case 0:
+		return []byte("info"), nil
+	case 1:
+		return []byte("debug"), nil
+	}
+	return nil, fmt.Errorf("unknown level %d", int(l))
+}
+
+func (l *Level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "info":
+		*l = 0
+	case "debug":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown level %s", text)
+	}
+	return nil
+}
+
+type Credentials struct {
+	User     string
+	Password string
+}
+
+func (c Credentials) MarshalCfg(key string, config *cfg.Config) error {
+	config.SetString(key+".user", c.User)
+	config.SetString(key+".password", c.Password)
+	return nil
+}
+
+func (c *Credentials) UnmarshalCfg(key string, config *cfg.Config) error {
+	var err error
+	c.User, err = config.GetString(key + ".user")
+	if err != nil {
+		return err
+	}
+	c.Password, err = config.GetString(key + ".password")
+	return err
+}
+
+func Test_UnmarshalTextUnmarshaler(t *testing.T) {
+	type TextConfig struct {
+		Level  Level
+		Levels []Level
+		Addr   net.IP
+		Bind   *net.IP
+		Proxy  *net.IP
+	}
+
+	textConfig := &TextConfig{}
+	conf := "level = debug\nlevels = info, debug\naddr = 10.0.0.1\nbind = ::1"
+	err := cfg.Unmarshal([]byte(conf), textConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+
+	if textConfig.Level != 1 || fmt.Sprint(textConfig.Levels) != "[0 1]" {
+		t.Errorf("Unexpected levels %v %v\n", textConfig.Level, textConfig.Levels)
+	}
+	if textConfig.Addr.String() != "10.0.0.1" {
+		t.Errorf("Expected addr 10.0.0.1 got %v\n", textConfig.Addr)
+	}
+	if textConfig.Bind == nil || textConfig.Bind.String() != "::1" {
+		t.Errorf("Expected bind ::1 got %v\n", textConfig.Bind)
+	}
+	if textConfig.Proxy != nil {
+		t.Errorf("Expected proxy to be nil got %v\n", textConfig.Proxy)
+	}
+
+	err = cfg.Unmarshal([]byte("level = verbose"), textConfig)
+	var valueErr *cfg.ValueError
+	if !errors.As(err, &valueErr) || valueErr.Value != "verbose" {
+		t.Errorf("Expected *cfg.ValueError for verbose got %v\n", err)
+	}
+}
+
+func Test_UnmarshalUnmarshaler(t *testing.T) {
+	type AuthConfig struct {
+		Admin Credentials  `cfg:"admin"`
+		Guest *Credentials `cfg:"guest"`
+	}
+
+	authConfig := &AuthConfig{}
+	conf := "[admin]\nuser = root\npassword = secret"
+	err := cfg.Unmarshal([]byte(conf), authConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+
+	if authConfig.Admin.User != "root" || authConfig.Admin.Password != "secret" {
+		t.Errorf("Unexpected admin %v\n", authConfig.Admin)
+	}
+	if authConfig.Guest != nil {
+		t.Errorf("Expected guest to be nil got %v\n", authConfig.Guest)
+	}
+
+	err = cfg.Unmarshal([]byte("guest.user = nobody"), authConfig)
+	var fieldErr *cfg.FieldError
+	if !errors.As(err, &fieldErr) || !errors.Is(err, cfg.ErrKeyNotFound) {
+		t.Errorf("Expected *cfg.FieldError wrapping ErrKeyNotFound got %v\n", err)
+	}
+}
+
+func Test_UnmarshalDefaults(t *testing.T) {
+	type ServerConfig struct {
+		Host    string        `cfg:"host" default:"localhost"`
+		Port    int           `cfg:"port" default:"8080"`
+		Tags    []string      `cfg:"tags" default:"a, b"`
+		Timeout time.Duration `cfg:"timeout" default:"5s"`
+		Debug   *bool         `cfg:"debug" default:"false"`
+		DB      DBConfigWithDefaults
+	}
+
+	serverConfig := &ServerConfig{}
+	err := cfg.Unmarshal([]byte("port = 9090\ndb.host = db.example.com"), serverConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+
+	if serverConfig.Host != "localhost" || serverConfig.Port != 9090 || serverConfig.Timeout != 5*time.Second {
+		t.Errorf("Unexpected config %v\n", serverConfig)
+	}
+	if fmt.Sprintf("%q", serverConfig.Tags) != `["a" "b"]` {
+		t.Errorf("Unexpected tags %q\n", serverConfig.Tags)
+	}
+	if serverConfig.Debug == nil || *serverConfig.Debug {
+		t.Errorf("Expected debug to be set to false got %v\n", serverConfig.Debug)
+	}
+	if serverConfig.DB.Port != 5432 {
+		t.Errorf("Expected default db port 5432 got %d\n", serverConfig.DB.Port)
+	}
+
+	type InvalidConfig struct {
+		Port int `default:"http"`
+	}
+	err = cfg.Unmarshal([]byte(""), &InvalidConfig{})
+	var fieldErr *cfg.FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "Port" {
+		t.Errorf("Expected *cfg.FieldError for Port got %v\n", err)
+	}
+}
+
+type DBConfigWithDefaults struct {
+	Host string `cfg:"host,required"`
+	Port int    `cfg:"port" default:"5432"`
+}
+
+func Test_UnmarshalRequired(t *testing.T) {
+	type RequiredConfig struct {
+		Name  string                `cfg:"name,required"`
+		Port  int                   `cfg:"port,required"`
+		Debug bool                  `cfg:"debug"`
+		DB    DBConfigWithDefaults  `cfg:"db"`
+		Cache *DBConfigWithDefaults `cfg:"cache"`
+	}
+
+	requiredConfig := &RequiredConfig{}
+	err := cfg.Unmarshal([]byte("name = app\n[db]\nport = 1"), requiredConfig)
+	var requiredErr *cfg.RequiredError
+	if !errors.As(err, &requiredErr) || !errors.Is(err, cfg.ErrKeyNotFound) {
+		t.Errorf("Expected *cfg.RequiredError got %v\n", err)
+	}
+	if requiredErr != nil && strings.Join(requiredErr.Keys, " ") != "port db.host" {
+		t.Errorf("Expected missing keys port db.host got %v\n", requiredErr.Keys)
+	}
+
+	err = cfg.Unmarshal([]byte("name = app\nport = 80\ndb.host = localhost"), requiredConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+}
+
+func Test_DecoderUnknownKeys(t *testing.T) {
+	type ServerConfig struct {
+		Timeout time.Duration `cfg:"timeout"`
+		DB      DBConfig      `cfg:"db"`
+		Limit   map[string]int
+	}
+
+	conf := "tiemout = 5s\nlimit.acme = 10\n\n[db]\nhost = localhost\nuser = admin"
+	config, err := cfg.NewConfigFromReader(strings.NewReader(conf))
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+
+	decoder := cfg.NewDecoder(config)
+	err = decoder.Decode(&ServerConfig{})
+	if err != nil {
+		t.Errorf("Error decoding config: %s\n", err)
+	}
+	if fmt.Sprint(decoder.UnusedKeys()) != "[tiemout db.user]" {
+		t.Errorf("Expected unused keys [tiemout db.user] got %v\n", decoder.UnusedKeys())
+	}
+
+	serverConfig := &ServerConfig{}
+	err = cfg.NewDecoder(config, cfg.DisallowUnknownKeys()).Decode(serverConfig)
+	var unknownErr *cfg.UnknownKeyError
+	if !errors.As(err, &unknownErr) {
+		t.Errorf("Expected *cfg.UnknownKeyError got %v\n", err)
+	}
+	expected := "cfg: unknown keys: tiemout (line 1), db.user (line 6)"
+	if err != nil && err.Error() != expected {
+		t.Errorf("Expected %q got %q\n", expected, err.Error())
+	}
+	if serverConfig.DB.Host != "localhost" {
+		t.Errorf("Expected the config to be decoded got %v\n", serverConfig)
+	}
+
+	config.Unset("tiemout")
+	config.Unset("db.user")
+	err = cfg.NewDecoder(config, cfg.DisallowUnknownKeys()).Decode(serverConfig)
+	if err != nil {
+		t.Errorf("Error decoding config: %s\n", err)
+	}
+}
+
+func Test_UnmarshalMatching(t *testing.T) {
+	type MatchConfig struct {
+		Port    int `cfg:"listen_port"`
+		Host    string
+		Timeout int
+	}
+
+	tests := []struct {
+		conf     string
+		expected MatchConfig
+	}{
+		{"Port = 1\nlisten_port = 2\nLISTEN_PORT = 3", MatchConfig{Port: 2}},
+		{"LISTEN_PORT = 3\nPort = 1", MatchConfig{Port: 1}},
+		{"port = 1\nLISTEN_PORT = 3", MatchConfig{Port: 3}},
+		{"host = a\nHost = b\nHOST = c", MatchConfig{Host: "b"}},
+		{"timeout = 5", MatchConfig{Timeout: 5}},
+	}
+	for _, test := range tests {
+		// Decode repeatedly since the keys are unordered in the config
+		for i := 0; i < 10; i++ {
+			matchConfig := MatchConfig{}
+			err := cfg.Unmarshal([]byte(test.conf), &matchConfig)
+			if err != nil {
+				t.Errorf("Error unmarshaling %q: %s\n", test.conf, err)
+			}
+			if matchConfig != test.expected {
+				t.Errorf("Expected %v for %q got %v\n", test.expected, test.conf, matchConfig)
+			}
+		}
+	}
+
+	err := cfg.Unmarshal([]byte("host = a\nHOST = b"), &MatchConfig{})
+	var ambiguousErr *cfg.AmbiguousKeyError
+	if !errors.As(err, &ambiguousErr) || ambiguousErr.Field != "Host" {
+		t.Errorf("Expected *cfg.AmbiguousKeyError for Host got %v\n", err)
+	}
+	if err != nil && err.Error() != "cfg: ambiguous keys for field Host: HOST, host" {
+		t.Errorf("Unexpected error message %q\n", err.Error())
+	}
+
+	err = cfg.Unmarshal([]byte("db.host = a\nDb.port = 1"), &struct{ DB DBConfig }{})
+	if !errors.As(err, &ambiguousErr) || ambiguousErr.Field != "DB" {
+		t.Errorf("Expected *cfg.AmbiguousKeyError for DB got %v\n", err)
+	}
+}
+
+func Test_DecoderCaseSensitive(t *testing.T) {
+	type MatchConfig struct {
+		Port int `cfg:"port"`
+		Host string
+	}
+
+	config, err := cfg.NewConfigFromReader(strings.NewReader("PORT = 1\nhost = a\nHOST = b"))
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+
+	matchConfig := &MatchConfig{}
+	decoder := cfg.NewDecoder(config, cfg.CaseSensitive())
+	err = decoder.Decode(matchConfig)
+	if err != nil {
+		t.Errorf("Error decoding config: %s\n", err)
+	}
+	if matchConfig.Port != 0 || matchConfig.Host != "" {
+		t.Errorf("Expected no matches got %v\n", matchConfig)
+	}
+	if fmt.Sprint(decoder.UnusedKeys()) != "[PORT host HOST]" {
+		t.Errorf("Expected unused keys [PORT host HOST] got %v\n", decoder.UnusedKeys())
+	}
+}
+
+func Test_UnmarshalValidation(t *testing.T) {
+	type ValidConfig struct {
+		Port    int           `cfg:"port" min:"1" max:"65535"`
+		Mode    string        `cfg:"mode" oneof:"debug release"`
+		Name    string        `cfg:"name,nonempty" max:"8"`
+		URL     string        `cfg:"url" pattern:"^https?://"`
+		Tags    []string      `cfg:"tags" oneof:"a b c" max:"2"`
+		Timeout time.Duration `cfg:"timeout" min:"1s" default:"5s"`
+		Ratio   *float64      `cfg:"ratio" min:"0" max:"1"`
+	}
+
+	validConfig := &ValidConfig{}
+	conf := "port = 8080\nmode = release\nname = app\nurl = https://example.com\ntags = a, c"
+	err := cfg.Unmarshal([]byte(conf), validConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+
+	conf = "port = 70000\nmode = verbose\nname =\nurl = ftp://example.com\ntags = a, d\ntimeout = 10ms\nratio = 1.5"
+	err = cfg.Unmarshal([]byte(conf), &ValidConfig{})
+	var validationErr *cfg.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Errorf("Expected *cfg.ValidationError got %v\n", err)
+		return
+	}
+
+	expected := []string{
+		`port (line 1) must be at most 65535`,
+		`mode (line 2) must be one of debug, release, not "verbose"`,
+		`name (line 3) must not be empty`,
+		`url (line 4) must match ^https?://, not "ftp://example.com"`,
+		`tags (line 5) must be one of a, b, c, not "d"`,
+		`timeout (line 6) must be at least 1s`,
+		`ratio (line 7) must be at most 1`,
+	}
+	if len(validationErr.Violations) != len(expected) {
+		t.Errorf("Expected %d violations got %v\n", len(expected), validationErr.Violations)
+	}
+	for i, v := range validationErr.Violations {
+		if i < len(expected) && v.String() != expected[i] {
+			t.Errorf("Expected %q got %q\n", expected[i], v.String())
+		}
+	}
+
+	err = cfg.Unmarshal([]byte("port = 1\nmode = debug"), &ValidConfig{})
+	if err == nil || err.Error() != "cfg: invalid values: name must not be empty; url must match ^https?://, not \"\"" {
+		t.Errorf("Unexpected error %v\n", err)
+	}
+
+	type InvalidConfig struct {
+		Enabled bool `min:"1"`
+	}
+	err = cfg.Unmarshal([]byte("enabled = true"), &InvalidConfig{})
+	var fieldErr *cfg.FieldError
+	if !errors.As(err, &fieldErr) {
+		t.Errorf("Expected *cfg.FieldError for invalid constraint got %v\n", err)
+	}
+}
+
+func Test_UnmarshalEnvOverrides(t *testing.T) {
+	os.Setenv("CFGTEST_DB_HOST", "db.example.com")
+	os.Setenv("CFGTEST_TIMEOUT", "10s")
+	os.Setenv("CFGTEST_LISTEN_PORT", "9090")
+	os.Setenv("CFGTEST_MOTD", "line1\nline2")
+	defer os.Unsetenv("CFGTEST_MOTD")
+	defer os.Unsetenv("CFGTEST_DB_HOST")
+	defer os.Unsetenv("CFGTEST_TIMEOUT")
+	defer os.Unsetenv("CFGTEST_LISTEN_PORT")
+
+	type EnvConfig struct {
+		DB      DBConfig      `cfg:"db"`
+		Timeout time.Duration `cfg:"timeout,required"`
+		Port    int           `cfg:"port" env:"CFGTEST_LISTEN_PORT"`
+		Name    string        `cfg:"name" default:"app"`
+		Motd    string        `cfg:"motd" env:"CFGTEST_MOTD"`
+	}
+
+	conf := "port = 8080\n[db]\nhost = localhost\nport = 5432"
+	config, err := cfg.NewConfigFromReader(strings.NewReader(conf), cfg.WithEnvPrefix("CFGTEST_"))
+	if err != nil {
+		t.Errorf("Error parsing config: %s\n", err)
+	}
+
+	envConfig := &EnvConfig{}
+	err = cfg.UnmarshalFromConfig(config, envConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling config: %s\n", err)
+	}
+
+	expected := EnvConfig{
+		DB:      DBConfig{Host: "db.example.com", Port: 5432},
+		Timeout: 10 * time.Second,
+		Port:    9090,
+		Name:    "app",
+		Motd:    "line1\nline2",
+	}
+	if *envConfig != expected {
+		t.Errorf("Expected %v got %v\n", expected, *envConfig)
+	}
+
+	// The env tag is used without a prefix
+	envConfig = &EnvConfig{}
+	err = cfg.Unmarshal([]byte(conf+"\n[]\ntimeout = 1s"), envConfig)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+	if envConfig.Port != 9090 || envConfig.DB.Host != "localhost" || envConfig.Timeout != time.Second {
+		t.Errorf("Unexpected config %v\n", envConfig)
+	}
+}
+
+type unexportedCredentials struct {
+	User string
+}
+
+func (c *unexportedCredentials) UnmarshalCfg(key string, config *cfg.Config) error {
+	c.User = "set"
+	return nil
+}
+
+type unexportedLevel struct {
+	Name string
+}
+
+func (l *unexportedLevel) UnmarshalText(text []byte) error {
+	l.Name = string(text)
+	return nil
+}
+
+func Test_UnmarshalUnexportedEmbedded(t *testing.T) {
+	type Outer struct {
+		unexportedCredentials
+		unexportedLevel
+		Name string
+	}
+
+	outer := &Outer{}
+	conf := "name = app\nunexportedCredentials = x\nunexportedLevel = debug"
+	err := cfg.Unmarshal([]byte(conf), outer)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+	if outer.Name != "app" || outer.unexportedCredentials.User != "" || outer.unexportedLevel.Name != "" {
+		t.Errorf("Expected only name to be set got %v\n", *outer)
+	}
+
+	if _, err := cfg.Marshal(outer); err != nil {
+		t.Errorf("Error marshaling data: %s\n", err)
+	}
+}
@@ -1,15 +1,25 @@
-// Package cfg implements a flat key value configuration that is read/writeable
+// Package cfg implements a key value configuration that is read/writeable
 // The format is simple and contains support for comments.
 // The purpose is to be a simple format that is easily read/modified by both
-// humans and computers. Comments are only modifiable by humans, but are readable
-// for computers.
+// humans and computers. Comments are attached to the key defined directly below
+// them and can be read and replaced per key.
 // The format is line based and all configurations are defined on their own line.
 // The format has support for four different types of values.
 // integers, floats, booleans and strings.
 // Integers are defined in decimal base.
 // Floats are defined without exponents e.g. 3.14 not 3.14E+00.
 // Booleans are defined as the string representation "true" or "false".
-// Strings are defined as is with all new lines escaped to only take up one line.
+// Strings are defined as is with all new lines escaped to only take up one line,
+// or surrounded by double quotes to keep leading and trailing whitespace.
+// Backslashes, double quotes and control characters are escaped with a
+// backslash, eg. \n for a new line and \u00e5 for the character å.
+//
+// Keys can be grouped in sections with INI-style [section] headers. A key
+// defined in a section is accessed with the section name and the key joined
+// by a dot, eg. "db.port".
+//
+// Values can reference other values as ${key} and environment variables as
+// ${env:NAME}, the references are replaced when the values are read.
 //
 // Example configuration
 //
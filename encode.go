@@ -2,10 +2,13 @@ package cfg
 
 import (
 	"bytes"
+	"encoding"
 	"errors"
 	"fmt"
 	"reflect"
-	"strings"
+	"sort"
+	"strconv"
+	"time"
 )
 
 // Marshal returns the config encoding of v.
@@ -13,10 +16,21 @@ import (
 //
 // Struct values encode as config values. Each exported struct field
 // becomes a member of the object unless
-//   - the field's tag is "-"
+//   - the field's tag is "-", or
+//   - the field is empty and its tag specifies the "omitempty" option.
 //
-// Only top level values of the supported types are encoded. No recursion
-// down the struct is done.
+// The empty values are the zero values of the types, and empty slices and
+// maps.
+//
+// Nested structs are encoded with the key of the struct field and the keys of
+// its fields joined by a dot, eg. "db.host". Embedded structs are encoded the
+// same way, unless the field's tag has the option "squash" or "inline", eg.
+// `cfg:",squash"`, in which case its fields are encoded as if they were
+// fields of the outer struct.
+//
+// All integer, unsigned integer, float, bool and string kinds, time.Duration
+// and time.Time are encoded, as well as slices and maps of them. Nil pointers
+// are omitted, other pointers are encoded as the value they point to.
 //
 // The object's default key string is the struct field name
 // but can be specified in the struct field's tag value. The "cfg" key in
@@ -28,6 +42,11 @@ import (
 //
 //   // Field appears in config as key "myName".
 //   Field int `cfg:"myName"`
+//
+//   // Field appears in config as key "myName" and
+//   // the field is omitted from the config if its value is empty,
+//   // as defined above.
+//   Field int `cfg:"myName,omitempty"`
 func Marshal(v interface{}) ([]byte, error) {
 	// Check that the type v we will read is a struct
 	rv := reflect.ValueOf(v)
@@ -35,36 +54,67 @@ func Marshal(v interface{}) ([]byte, error) {
 		return []byte{}, errors.New("cfg: interface must be a pointer to struct")
 	}
 
-	// Dereference the pointer
-	rv = rv.Elem()
-
 	buf := bytes.NewBuffer([]byte{})
+	err := writeStruct(buf, rv.Elem(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
 
+// writeStruct adds all fields of the struct rv to buffer, with their keys
+// prefixed by prefix.
+func writeStruct(buf *bytes.Buffer, rv reflect.Value, prefix string) error {
 	// Loop through all fields of the struct
 	for i := 0; i < rv.NumField(); i++ {
 		fv := rv.Field(i)
 		sf := rv.Type().Field(i)
 
 		// Check if the field should be skipped
-		if sf.PkgPath != "" { // unexported
+		if sf.PkgPath != "" && !(sf.Anonymous && fv.Kind() == reflect.Struct) { // unexported
+			continue
+		}
+		name, opts := parseTag(sf.Tag.Get(tagKey))
+		if name == "-" && opts == "" {
 			continue
 		}
-		tag := sf.Tag.Get(tagKey)
-		if tag == "-" {
+
+		if opts.Contains("omitempty") && isEmptyValue(fv) {
 			continue
 		}
 
 		key := sf.Name
-		if tag != "" {
-			key = tag
+		if name != "" {
+			key = name
 		}
 
-		err := writeValue(buf, &fv, key)
+		if fv.Kind() == reflect.Ptr && isStruct(fv.Type().Elem()) {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if isStruct(fv.Type()) {
+			if sf.Anonymous && opts.squash() {
+				key = ""
+			} else {
+				key += "."
+			}
+			err := writeStruct(buf, fv, prefix+key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := writeValue(buf, &fv, prefix+key)
 		if err != nil {
-			return nil, fmt.Errorf("cfg: error writing value: %s", err)
+			return fmt.Errorf("cfg: error writing value: %s", err)
 		}
 	}
-	return buf.Bytes(), nil
+
+	return nil
 }
 
 // MarshalToConfig creates a config object from the marshaled data in v.
@@ -72,10 +122,21 @@ func Marshal(v interface{}) ([]byte, error) {
 //
 // Struct values encode as config values. Each exported struct field
 // becomes a member of the object unless
-//   - the field's tag is "-"
+//   - the field's tag is "-", or
+//   - the field is empty and its tag specifies the "omitempty" option.
 //
-// Only top level values of the supported types are encoded. No recursion
-// down the struct is done.
+// The empty values are the zero values of the types, and empty slices and
+// maps.
+//
+// Nested structs are encoded with the key of the struct field and the keys of
+// its fields joined by a dot, eg. "db.host". Embedded structs are encoded the
+// same way, unless the field's tag has the option "squash" or "inline", eg.
+// `cfg:",squash"`, in which case its fields are encoded as if they were
+// fields of the outer struct.
+//
+// All integer, unsigned integer, float, bool and string kinds, time.Duration
+// and time.Time are encoded, as well as slices and maps of them. Nil pointers
+// are omitted, other pointers are encoded as the value they point to.
 //
 // The object's default key string is the struct field name
 // but can be specified in the struct field's tag value. The "cfg" key in
@@ -87,6 +148,11 @@ func Marshal(v interface{}) ([]byte, error) {
 //
 //   // Field appears in config as key "myName".
 //   Field int `cfg:"myName"`
+//
+//   // Field appears in config as key "myName" and
+//   // the field is omitted from the config if its value is empty,
+//   // as defined above.
+//   Field int `cfg:"myName,omitempty"`
 func MarshalToConfig(v interface{}) (*Config, error) {
 	data, err := Marshal(v)
 	if err != nil {
@@ -103,21 +169,123 @@ func MarshalToConfig(v interface{}) (*Config, error) {
 
 // writeValue adds the key value to buffer if it is exported and not skipped.
 func writeValue(buf *bytes.Buffer, fv *reflect.Value, key string) error {
-	switch fv.Kind() {
-	case reflect.Int:
-		_, err := buf.WriteString(fmt.Sprintf("%s = %v\n", key, fv.Int()))
-		return err
-	case reflect.Float64:
-		_, err := buf.WriteString(fmt.Sprintf("%s = %v\n", key, fv.Float()))
+	if fv.Kind() == reflect.Ptr && fv.IsNil() {
+		return nil
+	}
+	if m, ok := addrInterface(*fv).(Marshaler); ok {
+		c := NewConfig()
+		err := m.MarshalCfg(key, c)
+		if err != nil {
+			return err
+		}
+		if len(c.raw) > 0 {
+			_, err = buf.WriteString(c.String() + "\n")
+		}
 		return err
-	case reflect.Bool:
-		_, err := buf.WriteString(fmt.Sprintf("%s = %v\n", key, fv.Bool()))
+	}
+
+	switch {
+	case fv.Kind() == reflect.Ptr:
+		ev := fv.Elem()
+		return writeValue(buf, &ev, key)
+	case isEncodable(fv.Type()):
+		val, err := formatValue(*fv)
+		if err != nil {
+			return err
+		}
+		_, err = buf.WriteString(fmt.Sprintf("%s = %s\n", key, encodeValue(val)))
 		return err
-	case reflect.String:
-		qval := strings.Replace(fv.String(), "\n", "\\n", -1)
-		_, err := buf.WriteString(fmt.Sprintf("%s = %s\n", key, qval))
+	case fv.Kind() == reflect.Slice:
+		if !isEncodable(fv.Type().Elem()) {
+			return nil
+		}
+		elems := make([]string, 0, fv.Len())
+		for i := 0; i < fv.Len(); i++ {
+			val, err := formatValue(fv.Index(i))
+			if err != nil {
+				return err
+			}
+			elems = append(elems, val)
+		}
+		_, err := buf.WriteString(fmt.Sprintf("%s = %s\n", key, encodeList(elems)))
 		return err
+	case fv.Kind() == reflect.Map:
+		if fv.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		keys := make([]string, 0, fv.Len())
+		for _, k := range fv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ev := fv.MapIndex(reflect.ValueOf(k).Convert(fv.Type().Key()))
+			err := writeValue(buf, &ev, key+"."+k)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
+
+// isEmptyValue reports whether fv is the zero value of its type, or an empty
+// slice or map.
+func isEmptyValue(fv reflect.Value) bool {
+	switch fv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return fv.Len() == 0
+	}
+
+	return fv.IsZero()
+}
+
+// isEncodable reports whether values of type t are encoded as a single value.
+func isEncodable(t reflect.Type) bool {
+	return isScalar(t) || implements(t, textMarshalerType)
+}
+
+// formatValue returns the text representing the single value fv, without
+// any quoting or escaping. Values implementing encoding.TextMarshaler are
+// formated by MarshalText.
+func formatValue(fv reflect.Value) (string, error) {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return "", nil
+		}
+		fv = fv.Elem()
+	}
+	if fv.Type() != durationType && fv.Type() != timeType {
+		if m, ok := addrInterface(fv).(encoding.TextMarshaler); ok {
+			text, err := m.MarshalText()
+			return string(text), err
+		}
+	}
+
+	return formatScalar(fv), nil
+}
+
+// formatScalar returns the text representing the scalar value fv, without
+// any quoting or escaping.
+func formatScalar(fv reflect.Value) string {
+	switch fv.Type() {
+	case durationType:
+		return time.Duration(fv.Int()).String()
+	case timeType:
+		return fv.Interface().(time.Time).Format(time.RFC3339Nano)
+	}
+
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'g', -1, fv.Type().Bits())
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool())
+	}
+
+	return fv.String()
+}
@@ -1,7 +1,9 @@
 package cfg_test
 
 import (
+	"net"
 	"testing"
+	"time"
 
 	"github.com/walle/cfg"
 )
@@ -29,7 +31,7 @@ quotes = Alea iacta est\nEt tu, Brute?
 const myConfigEncodedWithEmpty = `Answer = 42
 Pi = 0
 is_active = false
-quotes = 
+quotes = ""
 `
 
 func Test_Marshal(t *testing.T) {
@@ -89,3 +91,202 @@ func Test_MarshalToConfig(t *testing.T) {
 		t.Errorf("Expected %q, got %q\n", q, quotes)
 	}
 }
+
+func Test_MarshalLists(t *testing.T) {
+	type ListConfig struct {
+		Hosts []string `cfg:"hosts"`
+		Ports []int    `cfg:"ports"`
+		Empty []string `cfg:"empty"`
+	}
+
+	data, err := cfg.Marshal(&ListConfig{
+		Hosts: []string{"a", "b, c"},
+		Ports: []int{80, 443},
+	})
+	if err != nil {
+		t.Errorf("Error encoding data: %s\n", err)
+	}
+
+	expected := "hosts = a, \"b, c\"\nports = 80, 443\nempty = \"\"\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q got %q\n", expected, string(data))
+	}
+}
+
+func Test_MarshalMaps(t *testing.T) {
+	type MapConfig struct {
+		Limit  map[string]int    `cfg:"limit"`
+		Labels map[string]string `cfg:"labels"`
+	}
+
+	mapConfig := &MapConfig{
+		Limit:  map[string]int{"globex": 20, "acme": 10},
+		Labels: map[string]string{"team": "core"},
+	}
+	data, err := cfg.Marshal(mapConfig)
+	if err != nil {
+		t.Errorf("Error encoding data: %s\n", err)
+	}
+
+	expected := "limit.acme = 10\nlimit.globex = 20\nlabels.team = core\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q got %q\n", expected, string(data))
+	}
+
+	decoded := &MapConfig{}
+	err = cfg.Unmarshal(data, decoded)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+	if decoded.Limit["acme"] != 10 || decoded.Labels["team"] != "core" {
+		t.Errorf("Unexpected decoded config %v\n", decoded)
+	}
+}
+
+func Test_MarshalNested(t *testing.T) {
+	type NestedConfig struct {
+		Common `cfg:",squash"`
+		DB     DBConfig `cfg:"db"`
+	}
+
+	nestedConfig := &NestedConfig{
+		Common: Common{Name: "app"},
+		DB:     DBConfig{Host: "db.example.com", Port: 5432},
+	}
+	data, err := cfg.Marshal(nestedConfig)
+	if err != nil {
+		t.Errorf("Error encoding data: %s\n", err)
+	}
+
+	expected := "name = app\ndb.host = db.example.com\ndb.port = 5432\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q got %q\n", expected, string(data))
+	}
+
+	decoded := &NestedConfig{}
+	err = cfg.Unmarshal(data, decoded)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+	if *decoded != *nestedConfig {
+		t.Errorf("Expected %v got %v\n", nestedConfig, decoded)
+	}
+}
+
+func Test_MarshalScalarKinds(t *testing.T) {
+	type ScalarConfig struct {
+		Int8    int8     `cfg:"int8"`
+		Uint64  uint64   `cfg:"uint64"`
+		Float32 float32  `cfg:"float32"`
+		Ports   []uint16 `cfg:"ports"`
+		Port    *int     `cfg:"port"`
+		Name    *string  `cfg:"name"`
+	}
+
+	port := 8080
+	scalarConfig := &ScalarConfig{
+		Int8:    -8,
+		Uint64:  18446744073709551615,
+		Float32: 0.1,
+		Ports:   []uint16{80, 443},
+		Port:    &port,
+	}
+	data, err := cfg.Marshal(scalarConfig)
+	if err != nil {
+		t.Errorf("Error encoding data: %s\n", err)
+	}
+
+	expected := "int8 = -8\nuint64 = 18446744073709551615\nfloat32 = 0.1\nports = 80, 443\nport = 8080\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q got %q\n", expected, string(data))
+	}
+}
+
+func Test_MarshalTimes(t *testing.T) {
+	type TimeConfig struct {
+		Timeout time.Duration `cfg:"timeout"`
+		Expires time.Time     `cfg:"expires"`
+	}
+
+	timeConfig := &TimeConfig{
+		Timeout: 90 * time.Second,
+		Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	data, err := cfg.Marshal(timeConfig)
+	if err != nil {
+		t.Errorf("Error encoding data: %s\n", err)
+	}
+
+	expected := "timeout = 1m30s\nexpires = 2030-01-02T03:04:05Z\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q got %q\n", expected, string(data))
+	}
+}
+
+func Test_MarshalMarshalers(t *testing.T) {
+	type MarshalerConfig struct {
+		Level  Level       `cfg:"level"`
+		Levels []Level     `cfg:"levels"`
+		Addr   net.IP      `cfg:"addr"`
+		Admin  Credentials `cfg:"admin"`
+	}
+
+	marshalerConfig := &MarshalerConfig{
+		Level:  1,
+		Levels: []Level{0, 1},
+		Addr:   net.ParseIP("10.0.0.1"),
+		Admin:  Credentials{User: "root", Password: "top secret"},
+	}
+	data, err := cfg.Marshal(marshalerConfig)
+	if err != nil {
+		t.Errorf("Error encoding data: %s\n", err)
+	}
+
+	expected := "level = debug\nlevels = info, debug\naddr = 10.0.0.1\nadmin.user = root\nadmin.password = top secret\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q got %q\n", expected, string(data))
+	}
+
+	decoded := &MarshalerConfig{}
+	err = cfg.Unmarshal(data, decoded)
+	if err != nil {
+		t.Errorf("Error unmarshaling data: %s\n", err)
+	}
+	if decoded.Level != 1 || !decoded.Addr.Equal(marshalerConfig.Addr) || decoded.Admin != marshalerConfig.Admin {
+		t.Errorf("Expected %v got %v\n", marshalerConfig, decoded)
+	}
+
+	_, err = cfg.Marshal(&MarshalerConfig{Level: 7})
+	if err == nil {
+		t.Errorf("Expected error for unknown level but got none\n")
+	}
+}
+
+func Test_MarshalOmitEmpty(t *testing.T) {
+	type OmitConfig struct {
+		Name  string         `cfg:"name,omitempty"`
+		Port  int            `cfg:"port,omitempty"`
+		Debug bool           `cfg:"debug"`
+		Tags  []string       `cfg:"tags,omitempty"`
+		Limit map[string]int `cfg:"limit,omitempty"`
+		Host  *string        `cfg:"host,omitempty"`
+		DB    DBConfig       `cfg:"db,omitempty"`
+	}
+
+	data, err := cfg.Marshal(&OmitConfig{Tags: []string{}})
+	if err != nil {
+		t.Errorf("Error encoding data: %s\n", err)
+	}
+	if string(data) != "debug = false\n" {
+		t.Errorf("Expected %q got %q\n", "debug = false\n", string(data))
+	}
+
+	data, err = cfg.Marshal(&OmitConfig{Port: 80, DB: DBConfig{Port: 5432}})
+	if err != nil {
+		t.Errorf("Error encoding data: %s\n", err)
+	}
+	expected := "port = 80\ndebug = false\ndb.host = \"\"\ndb.port = 5432\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q got %q\n", expected, string(data))
+	}
+}
@@ -0,0 +1,80 @@
+package cfg
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// Environment variables override values when the config is created with
+// WithEnvPrefix. The value of a variable is read as a value written in the
+// config, eg. quoted values and escape sequences are supported.
+//
+//	MYAPP_DB_HOST=db.example.com
+
+// envReplacer replaces the characters in keys that are not used in the names
+// of environment variables.
+var envReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// envName returns the name of the environment variable overriding key, or
+// an empty string if the config has no environment prefix.
+func (c *Config) envName(key string) string {
+	if c.envPrefix == "" {
+		return ""
+	}
+
+	return envVariable(c.envPrefix, key)
+}
+
+// envVariable returns the name of the environment variable with prefix
+// overriding key.
+func envVariable(prefix, key string) string {
+	return prefix + strings.ToUpper(envReplacer.Replace(key))
+}
+
+// ApplyEnvOverrides updates the values of all keys in the config that are
+// overridden by environment variables to the values of the variables, so
+// they are part of the config written by String and persisted by ConfigFile.
+// Only keys defined in the config are updated.
+// Returns the updated keys, in the order they are defined.
+func (c *Config) ApplyEnvOverrides() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0)
+	for key, value := range c.values {
+		if env, ok := c.lookupEnv(key); ok && env != value {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.index(keys[i]) < c.index(keys[j])
+	})
+	for _, key := range keys {
+		env, _ := c.lookupEnv(key)
+		c.set(key, env)
+	}
+
+	return keys
+}
+
+// lookupEnv returns the value of the environment variable overriding key,
+// if the config has an environment prefix and the variable is set. Line
+// breaks in the variable are escaped, so they are part of the value.
+// For the snapshot of a Stack the prefixes of the layers are used, for keys
+// that are not defined in any layer.
+func (c *Config) lookupEnv(key string) (string, bool) {
+	if _, defined := c.values[key]; !defined {
+		for _, prefix := range c.layerPrefixes {
+			if val, ok := os.LookupEnv(envVariable(prefix, key)); ok {
+				return singleLine(strings.TrimSpace(val)), true
+			}
+		}
+	}
+	if c.envPrefix == "" {
+		return "", false
+	}
+	val, ok := os.LookupEnv(c.envName(key))
+
+	return singleLine(strings.TrimSpace(val)), ok
+}
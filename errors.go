@@ -0,0 +1,169 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrKeyNotFound is returned, wrapped with the name of the key, when a key is
+// not defined in the config. Use errors.Is to check for it.
+var ErrKeyNotFound = errors.New("No such key")
+
+// ErrModifiedExternally is returned when persisting a ConfigFile whose file
+// has been modified by someone else since it was read.
+var ErrModifiedExternally = errors.New("cfg: file modified externally")
+
+// keyNotFound returns an error wrapping ErrKeyNotFound for key.
+func keyNotFound(key string) error {
+	return fmt.Errorf("%w (%s)", ErrKeyNotFound, key)
+}
+
+// ValueError describes a value that can not be converted to the requested
+// type.
+type ValueError struct {
+	Key   string // Key of the value
+	Value string // The value as defined in the config
+	Type  string // Name of the requested type, eg. integer
+	Err   error  // The error from the conversion
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("Invalid %s (%s)", e.Type, e.Err)
+}
+
+// Unwrap returns the error from the conversion.
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
+
+// FieldError describes a struct field that could not be set from the config.
+type FieldError struct {
+	Field string // Name of the struct field
+	Key   string // Key of the value in the config
+	Err   error  // The error setting the field
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("cfg: error setting field %s from key %s: %s", e.Field, e.Key, e.Err)
+}
+
+// Unwrap returns the error setting the field.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ParseError describes a malformed line in the source of a config.
+type ParseError struct {
+	Path   string // Path of the parsed file, empty if not parsed from a file
+	Line   int    // Line number, starting at 1
+	Column int    // Column of the problem in the line, starting at 1
+	Text   string // The malformed line
+	Reason string // Description of the problem
+}
+
+// newParseError creates a *ParseError for line, the n:th line in the source,
+// where offset is the byte offset of the problem in line.
+func newParseError(line string, n, offset int, reason string) *ParseError {
+	return &ParseError{
+		Line:   n,
+		Column: utf8.RuneCountInString(line[:offset]) + 1,
+		Text:   line,
+		Reason: reason,
+	}
+}
+
+func (e *ParseError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("cfg: line %d, column %d: %s", e.Line, e.Column, e.Reason)
+	}
+
+	return fmt.Sprintf("cfg: %s: line %d, column %d: %s", e.Path, e.Line, e.Column, e.Reason)
+}
+
+// RequiredError is returned when decoding into a struct with required fields
+// that are not found in the config.
+type RequiredError struct {
+	Keys []string // Keys of the missing fields
+}
+
+func (e *RequiredError) Error() string {
+	return fmt.Sprintf("cfg: missing required keys: %s", strings.Join(e.Keys, ", "))
+}
+
+// Unwrap returns ErrKeyNotFound.
+func (e *RequiredError) Unwrap() error {
+	return ErrKeyNotFound
+}
+
+// UnknownKeyError is returned when decoding with DisallowUnknownKeys and the
+// config has keys that do not match any struct field.
+type UnknownKeyError struct {
+	Keys  []string       // The unknown keys, in the order they are defined
+	Lines map[string]int // Line number of each unknown key, starting at 1
+}
+
+func (e *UnknownKeyError) Error() string {
+	keys := make([]string, 0, len(e.Keys))
+	for _, key := range e.Keys {
+		keys = append(keys, fmt.Sprintf("%s (line %d)", key, e.Lines[key]))
+	}
+
+	return fmt.Sprintf("cfg: unknown keys: %s", strings.Join(keys, ", "))
+}
+
+// AmbiguousKeyError is returned when decoding into a struct and several keys
+// in the config match a struct field equally well, eg. the keys "PORT" and
+// "port" both match the field Port case insensitive.
+type AmbiguousKeyError struct {
+	Field string   // Name of the struct field
+	Keys  []string // The matching keys, in sorted order
+}
+
+func (e *AmbiguousKeyError) Error() string {
+	return fmt.Sprintf("cfg: ambiguous keys for field %s: %s", e.Field, strings.Join(e.Keys, ", "))
+}
+
+// ValidationError is returned when decoding into a struct and values violate
+// the constraints in the tags of their struct fields.
+type ValidationError struct {
+	Violations []Violation // The violations, in the order of the fields
+}
+
+func (e *ValidationError) Error() string {
+	violations := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		violations = append(violations, v.String())
+	}
+
+	return fmt.Sprintf("cfg: invalid values: %s", strings.Join(violations, "; "))
+}
+
+// Violation describes a value violating a constraint of its struct field.
+type Violation struct {
+	Field  string // Name of the struct field
+	Key    string // Key of the value in the config
+	Line   int    // Line number of the key, starting at 1, or 0 if not defined
+	Reason string // Description of the violated constraint
+}
+
+// String returns the key, the line if defined, and the reason.
+func (v Violation) String() string {
+	if v.Line == 0 {
+		return fmt.Sprintf("%s %s", v.Key, v.Reason)
+	}
+
+	return fmt.Sprintf("%s (line %d) %s", v.Key, v.Line, v.Reason)
+}
+
+// ReferenceError describes a reference in a value that can not be resolved.
+type ReferenceError struct {
+	Key    string // Key of the value containing the reference
+	Ref    string // The reference, eg. "base" or "env:HOME"
+	Reason string // Description of the problem
+}
+
+func (e *ReferenceError) Error() string {
+	return fmt.Sprintf("cfg: can not resolve ${%s} in %s: %s", e.Ref, e.Key, e.Reason)
+}
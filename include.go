@@ -0,0 +1,163 @@
+package cfg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A config file can include other files with the directives include and
+// include_if_exists followed by a path. The lines of the included file are
+// read as if they were written in place of the directive, so keys in an
+// included file without a section belong to the section of the directive.
+// The path is relative to the directory of the including file and can be a
+// glob pattern, the matching files are included in sorted order. include
+// fails if no file matches, include_if_exists ignores missing files.
+//
+//	include base.cfg
+//	include_if_exists conf.d/*.cfg
+//
+// Includes are only read by NewConfigFile, other configs keep the directives
+// as lines without a value.
+
+// sourceLine is a line read from a file.
+type sourceLine struct {
+	text    string
+	path    string // Path of the included file, empty for the including file
+	n       int    // Line number in the file, starting at 1
+	include bool   // The line is an include directive
+}
+
+// readLines reads all lines from r, which has the contents of the file at
+// path.
+func readLines(r io.Reader, path string) ([]sourceLine, error) {
+	lines := make([]sourceLine, 0)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		lines = append(lines, sourceLine{text: scanner.Text(), path: path, n: n})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// includeDirective returns the path, or glob pattern, of the include
+// directive line and whether a matching file is required.
+// ok is false if line is not an include directive.
+func includeDirective(line string) (pattern string, required, ok bool) {
+	tline := strings.TrimSpace(line)
+	if strings.Contains(tline, "=") {
+		return "", false, false
+	}
+	for _, directive := range []string{"include", "include_if_exists"} {
+		rest := strings.TrimPrefix(tline, directive)
+		if rest == tline || strings.TrimSpace(rest) == "" || strings.TrimSpace(rest) == rest {
+			continue
+		}
+		return decodeValue(strings.TrimSpace(rest)), directive == "include", true
+	}
+
+	return "", false, false
+}
+
+// includer reads the files included by a config file.
+type includer struct {
+	stack  []string             // Files currently being read, to detect cycles
+	seen   map[string]bool      // Absolute paths of all files read
+	states map[string]fileState // State of each included file by its path
+}
+
+// expandIncludes returns the lines of the file at path, and the lines of all
+// files it includes in place of their include directives. The states of the
+// included files are added to states.
+func expandIncludes(lines []sourceLine, path string, states map[string]fileState) ([]sourceLine, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("cfg: could not resolve file: %s", err)
+	}
+	in := &includer{
+		stack:  []string{path},
+		seen:   map[string]bool{abs: true},
+		states: states,
+	}
+
+	return in.expand(lines, path)
+}
+
+// expand is the internal version of expandIncludes for the file at path.
+func (in *includer) expand(lines []sourceLine, path string) ([]sourceLine, error) {
+	expanded := make([]sourceLine, 0, len(lines))
+	for _, line := range lines {
+		pattern, required, ok := includeDirective(line.text)
+		if !ok {
+			expanded = append(expanded, line)
+			continue
+		}
+		line.include = true
+		expanded = append(expanded, line)
+		offset := strings.LastIndex(line.text, strings.Fields(line.text)[1])
+		fail := func(reason string) error {
+			pe := newParseError(line.text, line.n, offset, reason)
+			pe.Path = path
+			return pe
+		}
+
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fail(fmt.Sprintf("invalid pattern %s", pattern))
+		}
+		sort.Strings(matches)
+		if len(matches) == 0 && required {
+			return nil, fail(fmt.Sprintf("could not include %s, no such file", pattern))
+		}
+
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				continue
+			}
+			abs, err := filepath.Abs(match)
+			if err != nil {
+				return nil, fmt.Errorf("cfg: could not resolve file: %s", err)
+			}
+			for i, p := range in.stack {
+				if a, _ := filepath.Abs(p); a == abs {
+					cycle := append(append([]string(nil), in.stack[i:]...), match)
+					return nil, fail("include cycle " + strings.Join(cycle, " -> "))
+				}
+			}
+			if in.seen[abs] {
+				return nil, fail(fmt.Sprintf("file %s is already included", match))
+			}
+			in.seen[abs] = true
+
+			data, state, err := readFile(match)
+			if err != nil {
+				return nil, err
+			}
+			included, err := readLines(bytes.NewReader(data), match)
+			if err != nil {
+				return nil, fmt.Errorf("cfg: could not parse file: %s", err)
+			}
+			in.states[match] = state
+			in.stack = append(in.stack, match)
+			included, err = in.expand(included, match)
+			if err != nil {
+				return nil, err
+			}
+			in.stack = in.stack[:len(in.stack)-1]
+			expanded = append(expanded, included...)
+		}
+	}
+
+	return expanded, nil
+}
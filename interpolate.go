@@ -0,0 +1,83 @@
+package cfg
+
+import (
+	"os"
+	"strings"
+)
+
+// GetRawString returns the value for key as a string, as GetString but
+// without replacing references to other values and environment variables.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+func (c *Config) GetRawString(key string) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	val, ok := c.lookup(key)
+	if !ok {
+		return "", keyNotFound(key)
+	}
+
+	return decodeValue(val), nil
+}
+
+// expand returns the text raw of the value for key with all references
+// replaced by the escaped values they refer to. stack holds the keys of the
+// values currently being expanded, to detect cycles.
+func (c *Config) expand(key, raw string, stack []string) (string, error) {
+	if !strings.Contains(raw, "${") {
+		return raw, nil
+	}
+
+	stack = append(stack, key)
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		switch {
+		case raw[i] == '\\' && i+1 < len(raw):
+			b.WriteString(raw[i : i+2])
+			i++
+		case strings.HasPrefix(raw[i:], "${") && strings.Contains(raw[i:], "}"):
+			end := i + strings.Index(raw[i:], "}")
+			val, err := c.resolve(raw[i+2:end], stack)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(escape(val))
+			i = end
+		default:
+			b.WriteByte(raw[i])
+		}
+	}
+
+	return b.String(), nil
+}
+
+// resolve returns the value the reference ref refers to. stack holds the
+// keys of the values currently being expanded, the last being the value
+// containing the reference.
+func (c *Config) resolve(ref string, stack []string) (string, error) {
+	key := stack[len(stack)-1]
+	if strings.HasPrefix(ref, "env:") {
+		val, ok := os.LookupEnv(ref[len("env:"):])
+		if !ok {
+			return "", &ReferenceError{Key: key, Ref: ref, Reason: "environment variable not set"}
+		}
+		return val, nil
+	}
+
+	for i, k := range stack {
+		if k == ref {
+			cycle := append(append([]string(nil), stack[i:]...), ref)
+			return "", &ReferenceError{Key: key, Ref: ref, Reason: "reference cycle " + strings.Join(cycle, " -> ")}
+		}
+	}
+	raw, ok := c.lookup(ref)
+	if !ok {
+		return "", &ReferenceError{Key: key, Ref: ref, Reason: "no such key"}
+	}
+	raw, err := c.expand(ref, raw, stack)
+	if err != nil {
+		return "", err
+	}
+
+	return decodeValue(raw), nil
+}
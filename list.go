@@ -0,0 +1,224 @@
+package cfg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Lists are written as their elements separated by commas. Each element is a
+// bare or a quoted value, see GetString. Elements containing a comma must be
+// quoted.
+//
+//	hosts = alpha, "beta, gamma", delta
+//
+// An empty value is an empty list.
+
+// GetStrings returns the value for key as a list of strings.
+// With the CollectList policy the elements of all definitions of the key are
+// returned, in the order they are defined.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+func (c *Config) GetStrings(key string) ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.getList(key)
+}
+
+// GetInts returns the value for key as a list of ints in decimal base.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If an element can not be represented as an integer a *ValueError is
+// returned.
+func (c *Config) GetInts(key string) ([]int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	elems, err := c.getList(key)
+	if err != nil {
+		return nil, err
+	}
+	ints := make([]int, 0, len(elems))
+	for _, elem := range elems {
+		i, err := strconv.ParseInt(elem, 10, 64)
+		if err != nil {
+			return nil, &ValueError{Key: key, Value: elem, Type: "integer", Err: err}
+		}
+		ints = append(ints, int(i))
+	}
+
+	return ints, nil
+}
+
+// GetFloats returns the value for key as a list of float64s.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If an element can not be represented as a float a *ValueError is returned.
+func (c *Config) GetFloats(key string) ([]float64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	elems, err := c.getList(key)
+	if err != nil {
+		return nil, err
+	}
+	floats := make([]float64, 0, len(elems))
+	for _, elem := range elems {
+		f, err := strconv.ParseFloat(elem, 64)
+		if err != nil {
+			return nil, &ValueError{Key: key, Value: elem, Type: "float", Err: err}
+		}
+		floats = append(floats, f)
+	}
+
+	return floats, nil
+}
+
+// GetBools returns the value for key as a list of bools.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If an element can not be represented as a boolean a *ValueError is
+// returned.
+func (c *Config) GetBools(key string) ([]bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	elems, err := c.getList(key)
+	if err != nil {
+		return nil, err
+	}
+	bools := make([]bool, 0, len(elems))
+	for _, elem := range elems {
+		b, err := strconv.ParseBool(elem)
+		if err != nil {
+			return nil, &ValueError{Key: key, Value: elem, Type: "boolean", Err: err}
+		}
+		bools = append(bools, b)
+	}
+
+	return bools, nil
+}
+
+// SetStrings creates or updates a list value attached to key.
+// Elements are escaped and quoted as with SetString, and also quoted if they
+// contain a comma. A list with a single empty string is read back as an empty
+// list.
+func (c *Config) SetStrings(key string, values []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, encodeList(values))
+}
+
+// SetInts creates or updates a list value attached to key.
+// All integer values are formated in decimal base.
+func (c *Config) SetInts(key string, values []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elems := make([]string, 0, len(values))
+	for _, v := range values {
+		elems = append(elems, strconv.FormatInt(int64(v), 10))
+	}
+	c.set(key, encodeList(elems))
+}
+
+// SetFloats creates or updates a list value attached to key.
+// The float values are formated without exponents eg. 3.14 not 3.14E+00.
+func (c *Config) SetFloats(key string, values []float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elems := make([]string, 0, len(values))
+	for _, v := range values {
+		elems = append(elems, strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	c.set(key, encodeList(elems))
+}
+
+// SetBools creates or updates a list value attached to key.
+// The bool values are formated as "true" or "false".
+func (c *Config) SetBools(key string, values []bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elems := make([]string, 0, len(values))
+	for _, v := range values {
+		elems = append(elems, strconv.FormatBool(v))
+	}
+	c.set(key, encodeList(elems))
+}
+
+// getList is the internal getter for lists.
+// Returns the decoded elements of the value for key.
+func (c *Config) getList(key string) ([]string, error) {
+	raws := make([]string, 0, 1)
+	indexes := c.indexes(key)
+	if _, overridden := c.lookupEnv(key); c.duplicates == CollectList && len(indexes) > 1 && !overridden {
+		for _, i := range indexes {
+			_, val, _ := splitKeyValue(c.raw[i])
+			val, err := c.expand(key, val, nil)
+			if err != nil {
+				return nil, err
+			}
+			raws = append(raws, val)
+		}
+	} else {
+		val, err := c.get(key)
+		if err != nil {
+			return nil, err
+		}
+		raws = append(raws, val)
+	}
+
+	elems := make([]string, 0)
+	for _, raw := range raws {
+		elems = append(elems, decodeList(raw)...)
+	}
+
+	return elems, nil
+}
+
+// decodeList returns the elements of the list represented by the text raw.
+func decodeList(raw string) []string {
+	elems := make([]string, 0)
+	if strings.TrimSpace(raw) == "" {
+		return elems
+	}
+
+	quoted := false
+	start := 0
+	for i := 0; i < len(raw); i++ {
+		switch raw[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				elems = append(elems, decodeValue(strings.TrimSpace(raw[start:i])))
+				start = i + 1
+			}
+		}
+	}
+	elems = append(elems, decodeValue(strings.TrimSpace(raw[start:])))
+	if len(elems) == 1 && elems[0] == "" {
+		return elems[:0]
+	}
+
+	return elems
+}
+
+// encodeList returns the text representing the list with the elements elems.
+func encodeList(elems []string) string {
+	if len(elems) == 0 {
+		return `""`
+	}
+
+	encoded := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		if strings.Contains(elem, ",") {
+			encoded = append(encoded, `"`+escape(elem)+`"`)
+		} else {
+			encoded = append(encoded, encodeValue(elem))
+		}
+	}
+
+	return strings.Join(encoded, ", ")
+}
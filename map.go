@@ -0,0 +1,81 @@
+package cfg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Maps are written as one key for each entry, with the key of the entry
+// prefixed by the name of the map and a dot. The keys can also be written in a
+// section with the name of the map.
+//
+//	limit.acme = 10
+//	limit.globex = 20
+
+// GetMap returns all values with keys under prefix as a map of strings.
+// The keys in the map are the keys in the config without the prefix and the
+// following dot, eg. the key "limit.acme" is "acme" in the map for the prefix
+// "limit".
+// If no key is found under the prefix an error wrapping ErrKeyNotFound is
+// returned.
+func (c *Config) GetMap(prefix string) (map[string]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	raws, err := c.getMap(prefix)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(raws))
+	for k, raw := range raws {
+		m[k] = decodeValue(raw)
+	}
+
+	return m, nil
+}
+
+// GetIntMap returns all values with keys under prefix as a map of ints in
+// decimal base. See GetMap.
+// If no key is found under the prefix an error wrapping ErrKeyNotFound is
+// returned.
+// If a value can not be represented as an integer a *ValueError is returned.
+func (c *Config) GetIntMap(prefix string) (map[string]int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	raws, err := c.getMap(prefix)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]int, len(raws))
+	for k, raw := range raws {
+		i, err := strconv.ParseInt(decodeValue(raw), 10, 64)
+		if err != nil {
+			return nil, &ValueError{Key: prefix + "." + k, Value: raw, Type: "integer", Err: err}
+		}
+		m[k] = int(i)
+	}
+
+	return m, nil
+}
+
+// getMap is the internal getter for maps.
+// Returns the raw values, with all references replaced, of all keys under
+// prefix, by the keys without the prefix.
+func (c *Config) getMap(prefix string) (map[string]string, error) {
+	m := make(map[string]string)
+	for key := range c.values {
+		if strings.HasPrefix(key, prefix+".") {
+			raw, err := c.get(key)
+			if err != nil {
+				return nil, err
+			}
+			m[key[len(prefix)+1:]] = raw
+		}
+	}
+	if len(m) == 0 {
+		return nil, keyNotFound(prefix)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,54 @@
+package cfg
+
+import (
+	"encoding"
+	"reflect"
+)
+
+// Marshaler is the interface implemented by types that can marshal
+// themselves into config values.
+//
+// MarshalCfg sets the values representing the receiver in c. key is the key
+// of the field holding the value, the values can be set for key or for
+// multiple keys under it, eg. key + ".host" and key + ".port".
+type Marshaler interface {
+	MarshalCfg(key string, c *Config) error
+}
+
+// Unmarshaler is the interface implemented by types that can unmarshal
+// themselves from config values.
+//
+// UnmarshalCfg reads the values representing the receiver from c. key is the
+// key in c matching the field holding the value, either a key with a value
+// or the prefix of multiple keys, eg. key + ".host" and key + ".port".
+type Unmarshaler interface {
+	UnmarshalCfg(key string, c *Config) error
+}
+
+// Types of the interfaces that let values control their own encoding.
+var (
+	marshalerType       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// implements reports whether the type t, or a pointer to it, implements the
+// interface type it.
+func implements(t, it reflect.Type) bool {
+	return t.Implements(it) || t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(it)
+}
+
+// addrInterface returns the value in fv as an interface. A pointer to the
+// value is returned if it is addressable, so methods with pointer receivers
+// are found. Nil is returned if the value can not be used as an interface.
+func addrInterface(fv reflect.Value) interface{} {
+	if !fv.CanInterface() {
+		return nil
+	}
+	if fv.Kind() != reflect.Ptr && fv.CanAddr() {
+		return fv.Addr().Interface()
+	}
+
+	return fv.Interface()
+}
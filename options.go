@@ -0,0 +1,69 @@
+package cfg
+
+// Option configures how a Config is created and parsed.
+type Option func(*Config)
+
+// Strict enables strict parsing.
+// In strict mode every line must be empty, a comment, a section header or a
+// key value pair with both a key and a value. The first line that is not is
+// reported as a *ParseError.
+func Strict() Option {
+	return func(c *Config) {
+		c.strict = true
+	}
+}
+
+// DuplicatePolicy decides how keys that are defined more than once are
+// handled when parsing.
+type DuplicatePolicy int
+
+const (
+	// LastWins uses the last definition of a key. This is the default.
+	LastWins DuplicatePolicy = iota
+	// FirstWins uses the first definition of a key.
+	FirstWins
+	// DuplicateError reports the second definition of a key as a *ParseError.
+	DuplicateError
+	// CollectList keeps every definition of a key. GetAll returns all the
+	// values, all other getters use the last definition.
+	CollectList
+)
+
+// WithDuplicatePolicy sets how keys that are defined more than once are
+// handled, see DuplicatePolicy.
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(c *Config) {
+		c.duplicates = policy
+	}
+}
+
+// DecoderOption configures how a Decoder decodes a config.
+type DecoderOption func(*Decoder)
+
+// DisallowUnknownKeys makes the Decoder return an *UnknownKeyError when the
+// config has keys that do not match any struct field.
+func DisallowUnknownKeys() DecoderOption {
+	return func(dec *Decoder) {
+		dec.disallowUnknownKeys = true
+	}
+}
+
+// CaseSensitive makes the Decoder only match keys to struct fields with the
+// same case, by default keys also match case insensitive.
+func CaseSensitive() DecoderOption {
+	return func(dec *Decoder) {
+		dec.caseSensitive = true
+	}
+}
+
+// WithEnvPrefix makes environment variables override the values in the
+// config. The variable for a key is the prefix followed by the key in upper
+// case, with dots and dashes replaced by underscores, eg. the variable
+// MYAPP_DB_HOST for the key "db.host" or "db_host" with the prefix "MYAPP_".
+// The overrides are only used when reading values, they are not part of the
+// config written by String unless applied by ApplyEnvOverrides.
+func WithEnvPrefix(prefix string) Option {
+	return func(c *Config) {
+		c.envPrefix = prefix
+	}
+}
@@ -0,0 +1,347 @@
+package cfg
+
+import (
+	"strings"
+	"time"
+)
+
+// Source is a config that can be decoded into structs, see
+// UnmarshalFromConfig. *Config, *ConfigFile and *Stack are sources.
+type Source interface {
+	// Snapshot returns a copy of the config that is not affected by later
+	// changes.
+	Snapshot() *Config
+}
+
+// Layer is a named config in a Stack.
+type Layer struct {
+	Name   string
+	Config *Config
+}
+
+// Stack combines layers of configs, eg. flags, environment, user file,
+// system file and defaults. The value of a key is taken from the first layer
+// defining it. References in values, see GetString, are replaced by values
+// from the whole stack.
+// Values are changed in the layers, see Layer. The layers can be changed,
+// reloaded or persisted at any time and the stack reads their current values.
+// A Stack is safe for concurrent use by multiple goroutines.
+type Stack struct {
+	layers []Layer
+}
+
+// NewStack returns a new stack of layers, with the first layer winning.
+func NewStack(layers ...Layer) *Stack {
+	return &Stack{layers: append([]Layer(nil), layers...)}
+}
+
+// Layer returns the config of the layer with name, so that values can be
+// changed in that layer, or nil if the stack has no such layer.
+func (s *Stack) Layer(name string) *Config {
+	for _, l := range s.layers {
+		if l.Name == name {
+			return l.Config
+		}
+	}
+
+	return nil
+}
+
+// Origin returns the name of the first layer defining key, and the line
+// number of the definition in the config of that layer. The line number is 0
+// if the value comes from an environment variable, see WithEnvPrefix.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+func (s *Stack) Origin(key string) (layer string, line int, err error) {
+	for _, l := range s.layers {
+		if name, line, ok := l.origin(key); ok {
+			return name, line, nil
+		}
+	}
+
+	return "", 0, keyNotFound(key)
+}
+
+// origin is the internal version of Origin for a single layer.
+func (l Layer) origin(key string) (string, int, bool) {
+	l.Config.mu.RLock()
+	defer l.Config.mu.RUnlock()
+
+	if _, ok := l.Config.lookup(key); !ok {
+		return "", 0, false
+	}
+	if _, ok := l.Config.lookupEnv(key); ok {
+		return l.Name, 0, true
+	}
+
+	return l.Name, l.Config.lineNumber(l.Config.index(key)), true
+}
+
+// Snapshot returns a config with the value of every key defined in the stack,
+// taken from the first layer defining the key or overriding it by an
+// environment variable, in the order the keys are defined in the layers.
+// Keys only set by environment variables are read with the prefixes of the
+// layers. Changes to the snapshot do not affect the layers.
+// Line numbers in the snapshot do not refer to the layers, use Origin.
+func (s *Stack) Snapshot() *Config {
+	merged := NewConfig()
+	for _, l := range s.layers {
+		keys, prefix := l.keys()
+		for _, key := range keys {
+			if _, defined := merged.values[key]; defined {
+				continue
+			}
+			val, _ := s.lookup(key)
+			merged.raw = append(merged.raw, key+" = "+val)
+			merged.values[key] = val
+		}
+		if prefix != "" {
+			merged.layerPrefixes = append(merged.layerPrefixes, prefix)
+		}
+	}
+
+	return merged
+}
+
+// lookup returns the raw value for key from the first layer defining it, or
+// overriding it by an environment variable, and whether the key is found.
+func (s *Stack) lookup(key string) (string, bool) {
+	for _, l := range s.layers {
+		l.Config.mu.RLock()
+		val, ok := l.Config.lookup(key)
+		l.Config.mu.RUnlock()
+		if ok {
+			return val, true
+		}
+	}
+
+	return "", false
+}
+
+// keys returns the keys defined in the layer, in the order they are defined,
+// and the environment prefix of the layer.
+func (l Layer) keys() ([]string, string) {
+	l.Config.mu.RLock()
+	defer l.Config.mu.RUnlock()
+
+	keys := make([]string, 0, len(l.Config.values))
+	seen := make(map[string]bool, len(l.Config.values))
+	l.Config.scan(func(i int, section string) bool {
+		k, _, ok := splitKeyValue(l.Config.raw[i])
+		if key := qualify(section, k); ok && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	return keys, l.Config.envPrefix
+}
+
+// keysUnder returns the keys defined in the stack under prefix.
+func (s *Stack) keysUnder(prefix string) []string {
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, l := range s.layers {
+		layerKeys, _ := l.keys()
+		for _, key := range layerKeys {
+			if strings.HasPrefix(key, prefix+".") && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys
+}
+
+// config returns a config with the values of the keys found in the stack,
+// with all references replaced. The layers are only merged, see Snapshot, if
+// a value has references.
+func (s *Stack) config(keys ...string) (*Config, error) {
+	c := NewConfig()
+	var merged *Config
+	for _, key := range keys {
+		val, ok := s.lookup(key)
+		if !ok {
+			continue
+		}
+		if strings.Contains(val, "${") {
+			if merged == nil {
+				merged = s.Snapshot()
+			}
+			var err error
+			if val, err = merged.expand(key, val, nil); err != nil {
+				return nil, err
+			}
+		}
+		c.raw = append(c.raw, key+" = "+val)
+		c.values[key] = val
+	}
+
+	return c, nil
+}
+
+// GetString returns the value for key as a string, see Config.GetString.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+func (s *Stack) GetString(key string) (string, error) {
+	c, err := s.config(key)
+	if err != nil {
+		return "", err
+	}
+
+	return c.GetString(key)
+}
+
+// GetRawString returns the value for key as a string without replacing
+// references, see Config.GetRawString.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+func (s *Stack) GetRawString(key string) (string, error) {
+	val, ok := s.lookup(key)
+	if !ok {
+		return "", keyNotFound(key)
+	}
+
+	return decodeValue(val), nil
+}
+
+// GetInt returns the value for key as an int, see Config.GetInt.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If the value can not be represented as an integer a *ValueError is returned.
+func (s *Stack) GetInt(key string) (int, error) {
+	c, err := s.config(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.GetInt(key)
+}
+
+// GetFloat returns the value for key as a float64, see Config.GetFloat.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If the value can not be represented as a float a *ValueError is returned.
+func (s *Stack) GetFloat(key string) (float64, error) {
+	c, err := s.config(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.GetFloat(key)
+}
+
+// GetBool returns the value for key as a bool, see Config.GetBool.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If the value can not be represented as a boolean a *ValueError is returned.
+func (s *Stack) GetBool(key string) (bool, error) {
+	c, err := s.config(key)
+	if err != nil {
+		return false, err
+	}
+
+	return c.GetBool(key)
+}
+
+// GetDuration returns the value for key as a time.Duration, see
+// Config.GetDuration.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If the value can not be represented as a duration a *ValueError is
+// returned.
+func (s *Stack) GetDuration(key string) (time.Duration, error) {
+	c, err := s.config(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.GetDuration(key)
+}
+
+// GetTime returns the value for key as a time.Time, see Config.GetTime.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If the value can not be represented as a time a *ValueError is returned.
+func (s *Stack) GetTime(key string) (time.Time, error) {
+	c, err := s.config(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return c.GetTime(key)
+}
+
+// GetStrings returns the value for key as a list of strings, see
+// Config.GetStrings.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+func (s *Stack) GetStrings(key string) ([]string, error) {
+	c, err := s.config(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetStrings(key)
+}
+
+// GetInts returns the value for key as a list of ints, see Config.GetInts.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If an element can not be represented as an integer a *ValueError is
+// returned.
+func (s *Stack) GetInts(key string) ([]int, error) {
+	c, err := s.config(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetInts(key)
+}
+
+// GetFloats returns the value for key as a list of float64s, see
+// Config.GetFloats.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If an element can not be represented as a float a *ValueError is returned.
+func (s *Stack) GetFloats(key string) ([]float64, error) {
+	c, err := s.config(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetFloats(key)
+}
+
+// GetBools returns the value for key as a list of bools, see
+// Config.GetBools.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If an element can not be represented as a boolean a *ValueError is
+// returned.
+func (s *Stack) GetBools(key string) ([]bool, error) {
+	c, err := s.config(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetBools(key)
+}
+
+// GetMap returns all values with keys under prefix as a map of strings, see
+// Config.GetMap. Each value is taken from the first layer defining its key,
+// so the entries of the map can come from different layers.
+// If no key is found under the prefix an error wrapping ErrKeyNotFound is
+// returned.
+func (s *Stack) GetMap(prefix string) (map[string]string, error) {
+	c, err := s.config(s.keysUnder(prefix)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetMap(prefix)
+}
+
+// GetIntMap returns all values with keys under prefix as a map of ints, see
+// GetMap.
+// If no key is found under the prefix an error wrapping ErrKeyNotFound is
+// returned.
+// If a value can not be represented as an integer a *ValueError is returned.
+func (s *Stack) GetIntMap(prefix string) (map[string]int, error) {
+	c, err := s.config(s.keysUnder(prefix)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetIntMap(prefix)
+}
@@ -0,0 +1,35 @@
+package cfg
+
+import "strings"
+
+// tagOptions is the string following a comma in a struct field's "cfg" tag,
+// or the empty string.
+type tagOptions string
+
+// parseTag splits a struct field's "cfg" tag into its name and its
+// comma-separated options.
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+
+	return tag, tagOptions("")
+}
+
+// Contains reports whether the comma-separated list of options contains
+// option.
+func (o tagOptions) Contains(option string) bool {
+	for _, s := range strings.Split(string(o), ",") {
+		if s == option {
+			return true
+		}
+	}
+
+	return false
+}
+
+// squash reports whether the fields of an embedded struct should be treated
+// as if they were fields of the outer struct.
+func (o tagOptions) squash() bool {
+	return o.Contains("squash") || o.Contains("inline")
+}
@@ -0,0 +1,79 @@
+package cfg
+
+import (
+	"reflect"
+	"time"
+)
+
+// Durations are written in the format accepted by time.ParseDuration, eg.
+// 1h30m or 250ms. Times are written in the RFC 3339 format, eg.
+// 2006-01-02T15:04:05Z or 2006-01-02T15:04:05.5+07:00.
+//
+//	timeout = 5s
+//	expires = 2030-01-01T00:00:00Z
+
+// durationType and timeType are the types of durations and times, they are
+// encoded and decoded as single values.
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// GetDuration returns the value for key as a time.Duration.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If the value can not be represented as a duration a *ValueError is
+// returned.
+func (c *Config) GetDuration(key string) (time.Duration, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	val, err := c.get(key)
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(decodeValue(val))
+	if err != nil {
+		return 0, &ValueError{Key: key, Value: val, Type: "duration", Err: err}
+	}
+
+	return d, nil
+}
+
+// GetTime returns the value for key as a time.Time.
+// If the key is not found an error wrapping ErrKeyNotFound is returned.
+// If the value is not a time in the RFC 3339 format a *ValueError is
+// returned.
+func (c *Config) GetTime(key string) (time.Time, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	val, err := c.get(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339, decodeValue(val))
+	if err != nil {
+		return time.Time{}, &ValueError{Key: key, Value: val, Type: "time", Err: err}
+	}
+
+	return t, nil
+}
+
+// SetDuration creates or updates a value attached to key.
+// The duration is formated as by time.Duration.String eg. 1h30m0s.
+func (c *Config) SetDuration(key string, value time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value.String())
+}
+
+// SetTime creates or updates a value attached to key.
+// The time is formated in the RFC 3339 format, with fractional seconds if
+// there are any.
+func (c *Config) SetTime(key string, value time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value.Format(time.RFC3339Nano))
+}
@@ -0,0 +1,167 @@
+package cfg
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validate checks the value in fv against the constraints in the tags of the
+// struct field sf, with the key key in the config. Violations of the
+// constraints are added to the state, an error is returned if the
+// constraints can not be checked.
+func (d *decodeState) validate(fv reflect.Value, sf reflect.StructField, key string, opts tagOptions) error {
+	violate := func(reason string) {
+		d.violations = append(d.violations, Violation{Field: sf.Name, Key: key, Line: d.lines[key], Reason: reason})
+	}
+
+	if opts.Contains("nonempty") && isEmptyValue(fv) {
+		violate("must not be empty")
+	}
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return nil
+		}
+		fv = fv.Elem()
+	}
+
+	if min, ok := sf.Tag.Lookup("min"); ok {
+		n, err := compare(fv, min)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			violate("must be at least " + min)
+		}
+	}
+	if max, ok := sf.Tag.Lookup("max"); ok {
+		n, err := compare(fv, max)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			violate("must be at most " + max)
+		}
+	}
+
+	// Check the text of the value, or of each element of a slice
+	oneof, hasOneof := sf.Tag.Lookup("oneof")
+	pattern, hasPattern := sf.Tag.Lookup("pattern")
+	if !hasOneof && !hasPattern {
+		return nil
+	}
+	var re *regexp.Regexp
+	if hasPattern {
+		var err error
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+	}
+	elems := []reflect.Value{fv}
+	if fv.Kind() == reflect.Slice && !isEncodable(fv.Type()) {
+		elems = elems[:0]
+		for i := 0; i < fv.Len(); i++ {
+			elems = append(elems, fv.Index(i))
+		}
+	}
+	for _, ev := range elems {
+		text, err := formatValue(ev)
+		if err != nil {
+			return err
+		}
+		if hasOneof && !containsString(strings.Fields(oneof), text) {
+			violate(fmt.Sprintf("must be one of %s, not %q", strings.Join(strings.Fields(oneof), ", "), text))
+		}
+		if hasPattern && !re.MatchString(text) {
+			violate(fmt.Sprintf("must match %s, not %q", pattern, text))
+		}
+	}
+
+	return nil
+}
+
+// compare compares the value in fv with the limit, written as a value of the
+// same type in a config. Strings, slices and maps are compared by their
+// length. Returns -1, 0 or 1 if the value is less than, equal to or greater
+// than the limit.
+func compare(fv reflect.Value, limit string) (int, error) {
+	switch fv.Type() {
+	case durationType:
+		d, err := time.ParseDuration(limit)
+		if err != nil {
+			return 0, err
+		}
+		return compareFloat(float64(fv.Int()), float64(d)), nil
+	case timeType:
+		t, err := time.Parse(time.RFC3339, limit)
+		if err != nil {
+			return 0, err
+		}
+		v := fv.Interface().(time.Time)
+		if v.Before(t) {
+			return -1, nil
+		}
+		if v.After(t) {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case fv.Int() < i:
+			return -1, nil
+		case fv.Int() > i:
+			return 1, nil
+		}
+		return 0, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(limit, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case fv.Uint() < u:
+			return -1, nil
+		case fv.Uint() > u:
+			return 1, nil
+		}
+		return 0, nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(limit, 64)
+		if err != nil {
+			return 0, err
+		}
+		return compareFloat(fv.Float(), f), nil
+	case reflect.String, reflect.Slice, reflect.Map:
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return 0, err
+		}
+		return compareFloat(float64(fv.Len()), float64(n)), nil
+	}
+
+	return 0, fmt.Errorf("cfg: values of type %s can not be compared", fv.Type())
+}
+
+// compareFloat returns -1, 0 or 1 if a is less than, equal to or greater
+// than b.
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,291 @@
+package cfg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Values are either bare or quoted. A quoted value starts and ends with a
+// double quote, the quotes are not part of the value. All other values are
+// bare, they start at the first and end at the last non whitespace character.
+//
+// Both bare and quoted values support the escape sequences
+//
+//	\n     new line
+//	\r     carriage return
+//	\t     tab
+//	\\     backslash
+//	\"     double quote
+//	\$     dollar sign
+//	\uXXXX unicode character with the hexadecimal code point XXXX
+//	\xXX   byte with the hexadecimal value XX, for text that is not UTF-8
+//
+// Unknown escape sequences are kept as they are written.
+//
+// Values can reference other values as ${key}, and environment variables as
+// ${env:NAME}. The references are replaced when the value is read. Use \$ for
+// a dollar sign that does not start a reference.
+//
+// A value can span multiple lines. A line ending with a backslash continues
+// on the next line, with the leading whitespace of the next line removed.
+// A value starting with << followed by a delimiter is a heredoc. The value is
+// all lines after the first, up to the line containing only the delimiter,
+// exactly as they are written without any escape sequences.
+
+// decodeValue returns the value represented by the text raw.
+func decodeValue(raw string) string {
+	s, _ := unescape(trimQuotes(raw))
+	return s
+}
+
+// encodeValue returns the text representing the value s.
+// The text is quoted if s is empty, starts or ends with whitespace or starts
+// with a double quote or <<, otherwise it is bare.
+func encodeValue(s string) string {
+	if s == "" || strings.TrimSpace(s) != s || strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "<<") {
+		return `"` + escape(s) + `"`
+	}
+
+	return escape(s)
+}
+
+// lineBreakEscaper escapes line breaks in the text of a value.
+var lineBreakEscaper = strings.NewReplacer("\r", `\r`, "\n", `\n`)
+
+// singleLine returns the text raw of a value, that can contain line breaks
+// eg. when read from an environment variable, as text on a single line
+// representing the same value.
+func singleLine(raw string) string {
+	return lineBreakEscaper.Replace(raw)
+}
+
+// encodeHeredoc returns the heredoc text representing the value s.
+// ok is false if s has no line breaks or can not be represented as a heredoc.
+func encodeHeredoc(s string) (text string, ok bool) {
+	if !strings.Contains(s, "\n") || strings.Contains(s, "\r") {
+		return "", false
+	}
+
+	// Use a delimiter that is not a line in the value
+	lines := strings.Split(s, "\n")
+	delim := "EOF"
+	for i := 1; containsLine(lines, delim); i++ {
+		delim = "EOF" + strconv.Itoa(i)
+	}
+
+	return "<<" + delim + "\n" + s + "\n" + delim, true
+}
+
+// containsLine reports whether any of lines, without surrounding whitespace,
+// is s.
+func containsLine(lines []string, s string) bool {
+	for _, line := range lines {
+		if strings.TrimSpace(line) == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// heredocDelimiter returns the delimiter if raw is the start of a heredoc,
+// eg. <<EOF. The delimiter consists of letters, digits and underscores.
+func heredocDelimiter(raw string) (string, bool) {
+	if !strings.HasPrefix(raw, "<<") || len(raw) == 2 {
+		return "", false
+	}
+	for _, r := range raw[2:] {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return "", false
+		}
+	}
+
+	return raw[2:], true
+}
+
+// isHeredoc reports whether the key value pair entry has a heredoc value.
+func isHeredoc(entry string) bool {
+	first := strings.SplitN(entry, "\n", 2)[0]
+	eq := strings.Index(first, "=")
+	if eq == -1 || !strings.Contains(entry, "\n") {
+		return false
+	}
+	_, ok := heredocDelimiter(strings.TrimSpace(first[eq+1:]))
+
+	return ok
+}
+
+// continued reports whether line continues on the next line, that is if it
+// ends with a backslash that is not escaped.
+func continued(line string) bool {
+	n := len(line) - len(strings.TrimRight(line, `\`))
+	return n%2 == 1
+}
+
+// trimQuotes returns raw without the surrounding double quotes if raw is a
+// quoted value, otherwise raw is returned as is.
+func trimQuotes(raw string) string {
+	if len(raw) < 2 || raw[0] != '"' {
+		return raw
+	}
+	for i := 1; i < len(raw); i++ {
+		switch raw[i] {
+		case '\\':
+			i++
+		case '"':
+			if i == len(raw)-1 {
+				return raw[1:i]
+			}
+			return raw
+		}
+	}
+
+	return raw
+}
+
+// checkValue validates the syntax of the text raw.
+// Returns the byte offset in raw and a description of the first problem,
+// or -1 if there is no problem.
+func checkValue(raw string) (int, string) {
+	quoted := false
+	start := 0
+	for i := 0; i < len(raw); i++ {
+		switch raw[i] {
+		case '\\':
+			n := escapeLen(raw[i:])
+			if n == 0 {
+				return i, "invalid escape sequence"
+			}
+			i += n - 1
+		case '"':
+			if !quoted {
+				start = i
+			}
+			quoted = !quoted
+		}
+	}
+	if quoted {
+		return start, "unterminated quoted string"
+	}
+
+	return -1, ""
+}
+
+// escape returns s with backslashes, double quotes, line breaks, tabs, other
+// control characters, dollar signs starting references and bytes that are not
+// valid UTF-8 escaped.
+func escape(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == utf8.RuneError && !strings.HasPrefix(s[i:], string(utf8.RuneError)):
+			fmt.Fprintf(&b, `\x%02x`, s[i])
+		case r == '$' && strings.HasPrefix(s[i:], "${"):
+			b.WriteString(`\$`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '"':
+			b.WriteString(`\"`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// unescape returns s with all escape sequences replaced by the characters
+// they represent. Unknown escape sequences are kept as they are and reported
+// by ok being false.
+func unescape(s string) (string, bool) {
+	if !strings.Contains(s, `\`) {
+		return s, true
+	}
+
+	ok := true
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		n := escapeLen(s[i:])
+		if n == 0 {
+			ok = false
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'x':
+			b.WriteByte(byte(hexValue(s[i+2 : i+4])))
+		case 'u':
+			r := rune(hexValue(s[i+2 : i+6]))
+			// Combine surrogate pairs written as two escape sequences
+			if utf16.IsSurrogate(r) && escapeLen(s[i+6:]) == 6 && s[i+7] == 'u' {
+				if pair := utf16.DecodeRune(r, rune(hexValue(s[i+8:i+12]))); pair != unicode.ReplacementChar {
+					r = pair
+					n += 6
+				}
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte(s[i+1])
+		}
+		i += n - 1
+	}
+
+	return b.String(), ok
+}
+
+// escapeLen returns the length of the valid escape sequence at the start of
+// s, or 0 if s does not start with a valid escape sequence.
+func escapeLen(s string) int {
+	if len(s) < 2 || s[0] != '\\' {
+		return 0
+	}
+	switch s[1] {
+	case 'n', 'r', 't', '\\', '"', '$':
+		return 2
+	case 'x':
+		if len(s) < 4 || hexValue(s[2:4]) == -1 {
+			return 0
+		}
+		return 4
+	case 'u':
+		if len(s) < 6 || hexValue(s[2:6]) == -1 {
+			return 0
+		}
+		return 6
+	}
+
+	return 0
+}
+
+// hexValue returns the value of the hexadecimal number s, or -1 if s is not
+// a hexadecimal number.
+func hexValue(s string) int {
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return -1
+	}
+
+	return int(v)
+}